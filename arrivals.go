@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"pgregory.net/rand"
+)
+
+// An ArrivalProcess decides how the traces called for by the rate profile are
+// spread out in time. Gaps are measured in units of the mean time between
+// traces at the current target rate, so every process delivers the same
+// average rate and only the jitter differs.
+type ArrivalProcess interface {
+	// Next returns the gap before the next arrival and the number of traces
+	// that start together at that instant.
+	Next() (gap float64, n int)
+}
+
+// constantArrivals starts traces at perfectly regular intervals.
+type constantArrivals struct{}
+
+func (constantArrivals) Next() (float64, int) {
+	return 1, 1
+}
+
+// poissonArrivals starts traces independently of each other, so the gaps
+// between them are exponentially distributed.
+type poissonArrivals struct {
+	rng *rand.Rand
+}
+
+func (p poissonArrivals) Next() (float64, int) {
+	return p.rng.ExpFloat64(), 1
+}
+
+// paretoArrivals draws gaps from a Pareto distribution, which produces bursts
+// of closely-spaced traces separated by occasional long pauses. Smaller shapes
+// are burstier; the shape must be greater than 1 for the mean to exist.
+type paretoArrivals struct {
+	rng   *rand.Rand
+	shape float64
+	scale float64
+}
+
+func newParetoArrivals(rng *rand.Rand, shape float64) paretoArrivals {
+	// choose the scale so that the mean gap is 1
+	return paretoArrivals{rng: rng, shape: shape, scale: (shape - 1) / shape}
+}
+
+func (p paretoArrivals) Next() (float64, int) {
+	u := 1 - p.rng.Float64() // in (0, 1]
+	return p.scale / math.Pow(u, 1/p.shape), 1
+}
+
+// herdArrivals starts size traces at the same instant, then waits long enough
+// to keep the average rate on target.
+type herdArrivals struct {
+	size int
+}
+
+func (h herdArrivals) Next() (float64, int) {
+	return float64(h.size), h.size
+}
+
+func NewArrivalProcess(opts *Options) (ArrivalProcess, error) {
+	rng := rand.New()
+	switch opts.Quantity.Arrivals {
+	case "constant", "":
+		return constantArrivals{}, nil
+	case "poisson":
+		return poissonArrivals{rng: rng}, nil
+	case "pareto":
+		if opts.Quantity.ParetoShape <= 1 {
+			return nil, fmt.Errorf("paretoshape must be greater than 1, got %v", opts.Quantity.ParetoShape)
+		}
+		return newParetoArrivals(rng, opts.Quantity.ParetoShape), nil
+	case "herd":
+		if opts.Quantity.HerdSize < 1 {
+			return nil, fmt.Errorf("herdsize must be at least 1, got %d", opts.Quantity.HerdSize)
+		}
+		return herdArrivals{size: opts.Quantity.HerdSize}, nil
+	default:
+		return nil, fmt.Errorf("unknown arrival process %s", opts.Quantity.Arrivals)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"pgregory.net/rand"
+)
+
+func Test_ArrivalProcesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		arrivals ArrivalProcess
+		burst    int
+	}{
+		{"constant", constantArrivals{}, 1},
+		{"poisson", poissonArrivals{rng: rand.New(1)}, 1},
+		{"pareto", newParetoArrivals(rand.New(1), 1.5), 1},
+		{"pareto light tail", newParetoArrivals(rand.New(1), 3), 1},
+		{"herd", herdArrivals{size: 10}, 10},
+	}
+	const n = 200000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a gap is measured in traces, so divide it among the traces that
+			// start after it to get the mean gap per trace
+			var gaps float64
+			traces := 0
+			for range n {
+				gap, burst := tt.arrivals.Next()
+				if gap <= 0 || burst != tt.burst {
+					t.Fatalf("unexpected gap %v before a burst of %d", gap, burst)
+				}
+				gaps += gap
+				traces += burst
+			}
+			if mean := gaps / float64(traces); math.Abs(mean-1) > 0.05 {
+				t.Errorf("mean gap per trace is %v, want 1", mean)
+			}
+		})
+	}
+}
+
+func Test_NewArrivalProcess(t *testing.T) {
+	opts := newOptions()
+	for _, arrivals := range []string{"", "constant", "poisson", "pareto", "herd"} {
+		opts.Quantity.Arrivals = arrivals
+		opts.Quantity.ParetoShape = 1.5
+		opts.Quantity.HerdSize = 4
+		if _, err := NewArrivalProcess(opts); err != nil {
+			t.Errorf("%s: unexpected error %v", arrivals, err)
+		}
+	}
+	opts.Quantity.Arrivals = "herd"
+	if a, _ := NewArrivalProcess(opts); a != (herdArrivals{size: 4}) {
+		t.Errorf("expected herds of 4, got %+v", a)
+	}
+	opts.Quantity.HerdSize = 0
+	if _, err := NewArrivalProcess(opts); err == nil {
+		t.Errorf("expected an error for an empty herd")
+	}
+	opts.Quantity.Arrivals = "pareto"
+	opts.Quantity.ParetoShape = 1
+	if _, err := NewArrivalProcess(opts); err == nil {
+		t.Errorf("expected an error for a pareto shape with no mean")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaults for the batching of the senders that batch for themselves, when the
+// output options don't set them; these are the same as libhoney's
+const (
+	defaultBatchSize    = 50
+	defaultBatchTimeout = 100 * time.Millisecond
+	defaultQueueSize    = 10000
+)
+
+// A batchQueue collects items into batches, and hands each batch to one of a
+// pool of workers. A batch is sent when it's full, or when its oldest item has
+// waited for the batch timeout. Items added when the queue is full are
+// dropped, unless we're backfilling, when adding them blocks instead.
+type batchQueue[T any] struct {
+	queue   chan T
+	batches chan []T
+	size    int
+	timeout time.Duration
+	block   bool
+	send    func([]T)
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+}
+
+// newBatchQueue starts a batch queue that calls send with each batch, using the
+// batching options from opts and --concurrency workers.
+func newBatchQueue[T any](log Logger, opts *Options, send func([]T)) *batchQueue[T] {
+	if opts.Output.Concurrency < 1 {
+		log.Fatal("concurrency must be at least 1\n")
+	}
+	q := &batchQueue[T]{
+		size:    opts.Output.MaxExportBatchSize,
+		timeout: opts.Output.BatchTimeout,
+		block:   opts.Quantity.Backfill > 0,
+		send:    send,
+	}
+	if q.size == 0 {
+		q.size = defaultBatchSize
+	}
+	if q.timeout == 0 {
+		q.timeout = defaultBatchTimeout
+	}
+	queueSize := opts.Output.MaxQueueSize
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+	q.queue = make(chan T, queueSize)
+	q.batches = make(chan []T, opts.Output.Concurrency)
+
+	q.wg.Add(1 + opts.Output.Concurrency)
+	go q.batcher()
+	for i := 0; i < opts.Output.Concurrency; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// add queues an item to be batched.
+func (q *batchQueue[T]) add(item T) {
+	if q.block {
+		q.queue <- item
+		return
+	}
+	select {
+	case q.queue <- item:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// A queuedSendable is a span, in the form a batching sender sends it, waiting
+// to be queued when it ends.
+type queuedSendable[T any] struct {
+	queue *batchQueue[T]
+	item  T
+}
+
+func (s queuedSendable[T]) Send() {
+	s.queue.add(s.item)
+}
+
+// close sends everything that's queued, and waits for it to be sent.
+func (q *batchQueue[T]) close() {
+	close(q.queue)
+	q.wg.Wait()
+}
+
+func (q *batchQueue[T]) batcher() {
+	defer q.wg.Done()
+	defer close(q.batches)
+	var batch []T
+	timer := time.NewTimer(q.timeout)
+	timer.Stop()
+	flush := func() {
+		if len(batch) > 0 {
+			q.batches <- batch
+			batch = nil
+		}
+		timer.Stop()
+	}
+	for {
+		select {
+		case item, ok := <-q.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(q.timeout)
+			}
+			batch = append(batch, item)
+			if len(batch) >= q.size {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (q *batchQueue[T]) worker() {
+	defer q.wg.Done()
+	for batch := range q.batches {
+		q.send(batch)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/stats"
+)
+
+// compressions lists the codecs that each sender can compress requests with;
+// the first is the one it uses if --compression isn't given. The OTLP list
+// applies to metrics too, whatever the sender. Senders that aren't listed
+// don't compress anything.
+var compressions = map[string][]string{
+	"otel":      {"gzip", "none", "zstd", "snappy"},
+	"otellogs":  {"gzip", "none", "zstd", "snappy"},
+	"eventsapi": {"none", "gzip", "zstd"},
+	"zipkin":    {"none", "gzip"},
+	"jaeger":    {"none", "gzip"},
+	"honeycomb": {"zstd", "none"},
+}
+
+// ValidateCompression checks that a sender supports a codec. A sender that
+// doesn't compress anything supports none, unless OTLP metrics are sent
+// alongside its spans, when the codec is for them.
+func ValidateCompression(sender, codec string, metrics bool) error {
+	codecs, ok := compressions[sender]
+	if !ok && metrics {
+		codecs, ok = compressions["otel"]
+	}
+	switch {
+	case codec == "" || slices.Contains(codecs, codec):
+		return nil
+	case !ok:
+		return fmt.Errorf("the %s sender doesn't compress anything, so it can't use %s", sender, codec)
+	}
+	return fmt.Errorf("the %s sender doesn't support %s compression; it supports %s",
+		sender, codec, strings.Join(codecs, ", "))
+}
+
+// compression returns the codec that a sender uses: the one given with
+// --compression, or the sender's default.
+func compression(opts *Options, sender string) string {
+	if opts.Output.Compression != "" {
+		return opts.Output.Compression
+	}
+	if codecs, ok := compressions[sender]; ok {
+		return codecs[0]
+	}
+	return "none"
+}
+
+// A byteCounter counts the bytes of the requests a sender sends, before and
+// after compression, so that codecs can be compared.
+type byteCounter struct {
+	codec string
+	raw   atomic.Int64
+	sent  atomic.Int64
+}
+
+func (c *byteCounter) add(raw, sent int) {
+	c.raw.Add(int64(raw))
+	c.sent.Add(int64(sent))
+}
+
+// report logs how many bytes were sent, if any.
+func (c *byteCounter) report(log Logger) {
+	raw, sent := c.raw.Load(), c.sent.Load()
+	switch {
+	case raw == 0:
+	case c.codec == "none":
+		log.Warn("sender sent %d bytes uncompressed\n", sent)
+	default:
+		log.Warn("sender sent %d bytes compressed with %s from %d (%.1f%%)\n",
+			sent, c.codec, raw, 100*float64(sent)/float64(raw))
+	}
+}
+
+// zstdEncoder compresses whole request bodies; EncodeAll is safe for
+// concurrent use, and NewWriter can't fail without options.
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	e, _ := zstd.NewWriter(nil)
+	return e
+})
+
+// compress compresses a request body with a codec. Snappy uses the block
+// format, which is what OTLP/HTTP receivers expect.
+func compress(codec string, body []byte) ([]byte, error) {
+	switch codec {
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		return zstdEncoder().EncodeAll(body, nil), nil
+	case "snappy":
+		return snappy.Encode(nil, body), nil
+	default:
+		return body, nil
+	}
+}
+
+// compressingTransport compresses the bodies of OTLP/HTTP requests, and of
+// libhoney's requests, and counts their bytes. The OTel exporters can only
+// gzip requests themselves, and neither they nor libhoney say how big they
+// were, so they're given a client with this transport and told not to
+// compress anything.
+type compressingTransport struct {
+	base  http.RoundTripper
+	bytes *byteCounter
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := compress(t.bytes.codec, body)
+	if err != nil {
+		return nil, err
+	}
+	t.bytes.add(len(body), len(compressed))
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(compressed))
+	// net/http replays the body from GetBody if a connection turns out to be dead
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.ContentLength = int64(len(compressed))
+	if t.bytes.codec != "none" {
+		req.Header.Set("Content-Encoding", t.bytes.codec)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// otlpHTTPClient returns the client for an OTLP/HTTP exporter, which
+// compresses requests with the OTLP codec and counts their bytes.
+func otlpHTTPClient(opts *Options, bytes *byteCounter) *http.Client {
+	client := newHTTPClient(opts, 2)
+	client.Transport = &compressingTransport{base: client.Transport, bytes: bytes}
+	return client
+}
+
+// grpcDialOptions returns the options for an OTLP gRPC connection, which
+// compress requests with the OTLP codec and count their bytes. The exporters
+// only know about gzip, so the codec is set on the connection instead.
+func grpcDialOptions(bytes *byteCounter) []grpc.DialOption {
+	options := []grpc.DialOption{grpc.WithStatsHandler(grpcByteCounter{bytes})}
+	if bytes.codec != "none" {
+		options = append(options, grpc.WithDefaultCallOptions(grpc.UseCompressor(bytes.codec)))
+	}
+	return options
+}
+
+// grpcByteCounter is a gRPC stats handler that counts the bytes of the
+// messages a client sends.
+type grpcByteCounter struct {
+	bytes *byteCounter
+}
+
+func (h grpcByteCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h grpcByteCounter) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if out, ok := s.(*stats.OutPayload); ok {
+		h.bytes.add(out.Length, out.CompressedLength)
+	}
+}
+
+func (h grpcByteCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h grpcByteCounter) HandleConn(context.Context, stats.ConnStats) {}
+
+// grpcCompressor adds a codec to gRPC, which only has gzip built in. Snappy
+// uses the framed format, as the OTel Collector does.
+type grpcCompressor struct {
+	name       string
+	writer     func(io.Writer) (io.WriteCloser, error)
+	decompress func(io.Reader) (io.Reader, error)
+}
+
+func (c grpcCompressor) Name() string { return c.name }
+
+func (c grpcCompressor) Compress(w io.Writer) (io.WriteCloser, error) { return c.writer(w) }
+
+func (c grpcCompressor) Decompress(r io.Reader) (io.Reader, error) { return c.decompress(r) }
+
+// zstdWriters reuses zstd encoders, which are expensive to create, for gRPC
+// messages.
+var zstdWriters = sync.Pool{New: func() any {
+	e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return e
+}}
+
+// pooledZstdWriter returns its encoder to the pool when it's closed.
+type pooledZstdWriter struct {
+	*zstd.Encoder
+}
+
+func (w pooledZstdWriter) Close() error {
+	err := w.Encoder.Close()
+	zstdWriters.Put(w.Encoder)
+	return err
+}
+
+func init() {
+	encoding.RegisterCompressor(grpcCompressor{
+		name: "zstd",
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			e := zstdWriters.Get().(*zstd.Encoder)
+			e.Reset(w)
+			return pooledZstdWriter{e}, nil
+		},
+		decompress: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	})
+	encoding.RegisterCompressor(grpcCompressor{
+		name: "snappy",
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			return snappy.NewBufferedWriter(w), nil
+		},
+		decompress: func(r io.Reader) (io.Reader, error) {
+			return snappy.NewReader(r), nil
+		},
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func decompress(t *testing.T, codec string, body []byte) []byte {
+	t.Helper()
+	var out []byte
+	var err error
+	switch codec {
+	case "gzip":
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			out, err = io.ReadAll(zr)
+		}
+	case "zstd":
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(nil); err == nil {
+			out, err = zr.DecodeAll(body, nil)
+		}
+	case "snappy":
+		out, err = snappy.Decode(nil, body)
+	default:
+		out = body
+	}
+	if err != nil {
+		t.Fatalf("unable to decompress %s: %v", codec, err)
+	}
+	return out
+}
+
+func Test_ValidateCompression(t *testing.T) {
+	tests := []struct {
+		sender, codec string
+		metrics       bool
+		ok            bool
+	}{
+		{"otel", "snappy", false, true},
+		{"otellogs", "zstd", false, true},
+		{"eventsapi", "zstd", false, true},
+		{"eventsapi", "snappy", false, false},
+		{"zipkin", "gzip", false, true},
+		{"jaeger", "zstd", false, false},
+		{"honeycomb", "none", false, true},
+		{"honeycomb", "gzip", false, false},
+		{"honeycomb", "", false, true},
+		{"file", "snappy", false, false},
+		{"print", "none", false, false},
+		{"dummy", "", false, true},
+		{"dummy", "zstd", true, true},
+		{"dummy", "brotli", true, false},
+		{"zipkin", "zstd", true, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateCompression(tt.sender, tt.codec, tt.metrics); (err == nil) != tt.ok {
+			t.Errorf("ValidateCompression(%s, %s, %v) = %v", tt.sender, tt.codec, tt.metrics, err)
+		}
+	}
+	opts := &Options{}
+	if compression(opts, "otel") != "gzip" || compression(opts, "zipkin") != "none" || compression(opts, "print") != "none" {
+		t.Errorf("unexpected default compression")
+	}
+}
+
+func checkBytes(t *testing.T, codec string, bytes *byteCounter) {
+	t.Helper()
+	raw, sent := bytes.raw.Load(), bytes.sent.Load()
+	if raw == 0 || (codec == "none") != (raw == sent) {
+		t.Errorf("%s: unexpected byte counts %d and %d", codec, raw, sent)
+	}
+}
+
+func Test_OTLPHTTPCompression(t *testing.T) {
+	for _, codec := range []string{"none", "gzip", "zstd", "snappy"} {
+		var received atomic.Int64
+		host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.Header.Get("Content-Encoding")
+			if encoding == "" {
+				encoding = "none"
+			}
+			if encoding != codec {
+				t.Errorf("expected %s encoding, got %s", codec, encoding)
+			}
+			body, _ := io.ReadAll(r.Body)
+			var req coltracepb.ExportTraceServiceRequest
+			if err := proto.Unmarshal(decompress(t, codec, body), &req); err != nil {
+				t.Errorf("%s: unable to decode request: %v", codec, err)
+			}
+			received.Add(int64(len(req.ResourceSpans)))
+			w.Header().Set("Content-Type", "application/x-protobuf")
+		})
+
+		opts := &Options{}
+		opts.Telemetry.Insecure = true
+		opts.Output.Protocol = "http"
+		opts.Output.Compression = codec
+		opts.apihost = host
+		sender := NewSenderOTel(NewLogger(0), opts)
+		sendSpans(t, sender, 0)
+		checkBytes(t, codec, sender.bytes)
+
+		if received.Load() != 1 {
+			t.Errorf("%s: expected a request with 1 resource, got %d", codec, received.Load())
+		}
+	}
+}
+
+// traceService is an OTLP trace receiver that counts the spans it receives.
+type traceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	spans atomic.Int64
+}
+
+func (s *traceService) Export(_ context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			s.spans.Add(int64(len(ss.Spans)))
+		}
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func Test_OTLPGRPCCompression(t *testing.T) {
+	for _, codec := range []string{"none", "gzip", "zstd", "snappy"} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := &traceService{}
+		server := grpc.NewServer()
+		coltracepb.RegisterTraceServiceServer(server, service)
+		go server.Serve(lis)
+
+		opts := &Options{}
+		opts.Telemetry.Insecure = true
+		opts.Output.Protocol = "grpc"
+		opts.Output.Compression = codec
+		opts.apihost = parseHost(NewLogger(0), "http://"+lis.Addr().String(), true)
+		sender := NewSenderOTel(NewLogger(0), opts)
+		sendSpans(t, sender, 0)
+		server.Stop()
+
+		if service.spans.Load() != 1 {
+			t.Errorf("%s: expected 1 span, got %d", codec, service.spans.Load())
+		}
+		checkBytes(t, codec, sender.bytes)
+	}
+}
+
+func Test_byteCounter(t *testing.T) {
+	var received atomic.Int64
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Add(int64(len(body)))
+	})
+
+	opts := &Options{}
+	opts.Output.Retries = 0
+	poster := newHTTPPoster(NewLogger(0), opts, host.String(), nil, "zstd")
+	body := bytes.Repeat([]byte("loadgen "), 1000)
+	resp, err := poster.post(body, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if poster.bytes.raw.Load() != int64(len(body)) || poster.bytes.sent.Load() != received.Load() || received.Load() >= int64(len(body)) {
+		t.Errorf("expected %d bytes compressed to %d, counted %d compressed to %d",
+			len(body), received.Load(), poster.bytes.raw.Load(), poster.bytes.sent.Load())
+	}
+}
+
+// roundTripFunc is an http.RoundTripper made from a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_compressingTransport(t *testing.T) {
+	body := bytes.Repeat([]byte("loadgen "), 1000)
+	transport := &compressingTransport{
+		base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent, _ := io.ReadAll(req.Body)
+			// a retry on a new connection sends the body from GetBody
+			replay, err := req.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed, _ := io.ReadAll(replay)
+			if !bytes.Equal(sent, replayed) || int64(len(sent)) != req.ContentLength {
+				t.Errorf("sent %d bytes with a content length of %d, but GetBody gives %d",
+					len(sent), req.ContentLength, len(replayed))
+			}
+			if !bytes.Equal(decompress(t, "gzip", sent), body) || req.Header.Get("Content-Encoding") != "gzip" {
+				t.Errorf("the body wasn't compressed with gzip")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+		bytes: &byteCounter{codec: "gzip"},
+	}
+	req, err := http.NewRequest("POST", "http://localhost/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"pgregory.net/rand"
+)
+
+// A SpanError describes how a span failed. Errors are decided when a trace is
+// planned, so that every sender reports the same spans as failing.
+type SpanError struct {
+	Type       string
+	Message    string
+	Stacktrace string
+	Propagated bool // the span failed because one of its children did
+}
+
+// Fields returns the error as fields for senders that send plain events.
+func (e *SpanError) Fields() map[string]any {
+	return map[string]any{
+		"error":                true,
+		"status_message":       e.Message,
+		"exception.type":       e.Type,
+		"exception.message":    e.Message,
+		"exception.stacktrace": e.Stacktrace,
+		"error.propagated":     e.Propagated,
+	}
+}
+
+// an exception is a template for a realistic error from one of several languages
+type exception struct {
+	lang    string
+	typ     string
+	message string
+}
+
+var exceptions = []exception{
+	{"go", "*net.OpError", "dial tcp 10.0.3.17:5432: connect: connection refused"},
+	{"go", "context.deadlineExceededError", "context deadline exceeded"},
+	{"go", "*errors.errorString", "sql: no rows in result set"},
+	{"go", "*status.Error", "rpc error: code = Unavailable desc = connection error"},
+	{"java", "java.lang.NullPointerException", `Cannot invoke "String.length()" because "name" is null`},
+	{"java", "java.net.SocketTimeoutException", "Read timed out"},
+	{"java", "java.lang.IllegalStateException", "Connection pool exhausted"},
+	{"python", "ValueError", "invalid literal for int() with base 10: 'abc'"},
+	{"python", "KeyError", "'user_id'"},
+	{"python", "redis.exceptions.ConnectionError", "Error 111 connecting to cache:6379. Connection refused."},
+	{"node", "TypeError", "Cannot read properties of undefined (reading 'id')"},
+	{"node", "Error", "ECONNRESET"},
+}
+
+// wrappers are the exception types used in each language when a call fails
+var wrappers = map[string]string{
+	"go":     "*fmt.wrapError",
+	"java":   "java.lang.RuntimeException",
+	"python": "RuntimeError",
+	"node":   "Error",
+}
+
+var languages = []string{"go", "java", "python", "node"}
+
+// language returns the language a service is written in, which is always the
+// same for a given service name.
+func language(service string) string {
+	h := fnv.New32a()
+	h.Write([]byte(service))
+	return languages[h.Sum32()%uint32(len(languages))]
+}
+
+// randomException returns one of the exceptions for the given language.
+func randomException(lang string) exception {
+	var choices []exception
+	for _, x := range exceptions {
+		if x.lang == lang {
+			choices = append(choices, x)
+		}
+	}
+	return choices[rand.Intn(len(choices))]
+}
+
+// identifier turns a span name like "GET /cart" into something that looks like
+// a function name, like "GETCart".
+func identifier(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z' && upper:
+			b.WriteRune(r - 'a' + 'A')
+			upper = false
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	if b.Len() == 0 {
+		return "handle"
+	}
+	return b.String()
+}
+
+// stacktrace makes up a plausible stack trace in the exception's language for
+// a failure in the given service and operation.
+func (x exception) stacktrace(service, operation string) string {
+	svc, op := identifier(service), identifier(operation)
+	line := func() int { return 20 + rand.Intn(400) }
+	switch x.lang {
+	case "java":
+		return fmt.Sprintf("%s: %s\n\tat com.example.%s.Handler.%s(Handler.java:%d)\n\tat com.example.%s.Server.dispatch(Server.java:%d)\n\tat java.base/java.lang.Thread.run(Thread.java:833)",
+			x.typ, x.message, strings.ToLower(svc), op, line(), strings.ToLower(svc), line())
+	case "python":
+		return fmt.Sprintf("Traceback (most recent call last):\n  File \"/app/%s/server.py\", line %d, in dispatch\n    return handler(request)\n  File \"/app/%s/handlers.py\", line %d, in %s\n    raise %s(%q)\n%s: %s",
+			svc, line(), svc, line(), strings.ToLower(op), x.typ, x.message, x.typ, x.message)
+	case "node":
+		return fmt.Sprintf("%s: %s\n    at %s (/app/%s/src/handlers.js:%d:%d)\n    at process.processTicksAndRejections (node:internal/process/task_queues:95:5)",
+			x.typ, x.message, op, svc, line(), 1+rand.Intn(40))
+	default:
+		return fmt.Sprintf("goroutine %d [running]:\nmain.(*%sServer).%s(...)\n\t/app/%s/handlers.go:%d\nmain.(*%sServer).ServeHTTP(...)\n\t/app/%s/server.go:%d",
+			1+rand.Intn(1000), svc, op, svc, line(), svc, svc, line())
+	}
+}
+
+// An ErrorModel decides which spans in a trace fail. Each span fails with a
+// probability that depends on its service or level, and if propagation is on, a
+// span whose child fails fails too, all the way up to the root.
+type ErrorModel struct {
+	rates     map[string]float64
+	propagate bool
+}
+
+// NewErrorModel takes a map of level numbers, service names, or "*" to the
+// percentage of spans that fail; a service name takes precedence over a level.
+func NewErrorModel(rates map[string]float64, propagate bool) (*ErrorModel, error) {
+	for key, rate := range rates {
+		if rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("error rate for %s must be between 0 and 100, not %v", key, rate)
+		}
+	}
+	return &ErrorModel{rates: rates, propagate: propagate}, nil
+}
+
+// rate returns the error percentage for a span.
+func (m *ErrorModel) rate(span *SpanPlan) float64 {
+	for _, key := range []string{span.ServiceName(), strconv.Itoa(span.Level), "*"} {
+		if rate, ok := m.rates[key]; ok {
+			return rate
+		}
+	}
+	return 0
+}
+
+// Apply decides which spans in the trace fail and records their errors in the
+// plan. It returns true if the span failed.
+func (m *ErrorModel) Apply(span *SpanPlan) bool {
+	var failed *SpanPlan
+	for _, child := range span.Children {
+		// the parent doesn't wait for a detached child, so it never sees it fail
+		if m.Apply(child) && failed == nil && !child.Detached {
+			failed = child
+		}
+	}
+	lang := language(span.ServiceName())
+	if rate := m.rate(span); rate > 0 && rand.Float64()*100 < rate {
+		x := randomException(lang)
+		span.Error = &SpanError{
+			Type:       x.typ,
+			Message:    x.message,
+			Stacktrace: x.stacktrace(span.ServiceName(), span.Name),
+		}
+	} else if m.propagate && failed != nil {
+		x := exception{lang: lang, typ: wrappers[lang],
+			message: fmt.Sprintf("call to %s failed: %s", failed.ServiceName(), failed.Error.Message)}
+		span.Error = &SpanError{
+			Type:       x.typ,
+			Message:    x.message,
+			Stacktrace: x.stacktrace(span.ServiceName(), span.Name),
+			Propagated: true,
+		}
+	}
+	return span.Error != nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ErrorModel(t *testing.T) {
+	root := newRootPlan("frontend", time.Now())
+	cart := root.addChild("cart", root.Start)
+	db := cart.addChild("db", root.Start)
+	other := root.addChild("search", root.Start)
+
+	m, err := NewErrorModel(map[string]float64{"db": 100, "*": 0}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Apply(root) {
+		t.Fatal("expected the root to fail")
+	}
+	if db.Error == nil || db.Error.Propagated || db.Error.Stacktrace == "" {
+		t.Errorf("unexpected db error %+v", db.Error)
+	}
+	if cart.Error == nil || !cart.Error.Propagated || root.Error == nil || !root.Error.Propagated {
+		t.Errorf("expected the error to propagate, got %+v and %+v", cart.Error, root.Error)
+	}
+	if other.Error != nil {
+		t.Errorf("unexpected error in sibling %+v", other.Error)
+	}
+
+	if _, err := NewErrorModel(map[string]float64{"1": 101}, false); err == nil {
+		t.Errorf("expected error for rate over 100")
+	}
+}
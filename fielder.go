@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -57,7 +58,7 @@ var constfield = regexp.MustCompile(`^([^/].*)$`)
 
 // genfield is used to parse generator fields by matching valid commands and numeric arguments
 // the second parameter, if it exists, includes the comma
-var genfield = regexp.MustCompile(`^/([ibfsuk][awxrgqt]?)([0-9.-]+)?(,[0-9.-]+)?$`)
+var genfield = regexp.MustCompile(`^/([ibfsuk][awxrgqtp]?)([0-9.-]+)?(,[0-9.-]+)?$`)
 
 // keysplitter separates fields that look like number.name (ex: 1.myfield)
 var keysplitter = regexp.MustCompile(`^([0-9]+)\.(.*$)`)
@@ -313,8 +314,8 @@ func parseUserFields(rng Rng, userfields map[string]string) (map[string]func() a
 			if err != nil {
 				return nil, fmt.Errorf("invalid key in key field %s=%s: %w", name, value, err)
 			}
-		case "u", "uq":
-			// Generate a URL-like string with a random path and possibly a query string
+		case "u", "uq", "up":
+			// Generate a URL-like string with a random path and possibly a query string, or just the path
 			fields[name], err = getURLGen(rng, gentype, p1, p2)
 			if err != nil {
 				return nil, fmt.Errorf("invalid float in user field %s=%s: %w", name, value, err)
@@ -472,6 +473,10 @@ func getURLGen(rng Rng, gentype, p1, p2 string) (func() any, error) {
 		return func() any {
 			return "https://example.com/" + path1() + "/" + path2() + "?extra=" + rng.String(10)
 		}, nil
+	} else if gentype == "up" {
+		return func() any {
+			return "/" + path1() + "/" + path2()
+		}, nil
 	} else {
 		return func() any {
 			return "https://example.com/" + path1() + "/" + path2()
@@ -506,9 +511,64 @@ func getKeyGen(rng Rng, p1, p2 string) (func() any, error) {
 	return func() any { return ep.getEligibleWord(time.Since(startTime)) }, nil
 }
 
+// templatefield finds generators embedded in a name template, like "GET {/up5,10}"
+var templatefield = regexp.MustCompile(`\{([^}]*)\}`)
+
+// parseNameTemplate parses an operation name spec. A spec is a list of
+// alternatives separated by |, one of which is chosen at random each time; each
+// alternative is a generator (like /sw12), or text with generators embedded in
+// braces (like "GET {/up5,10}").
+func parseNameTemplate(rng Rng, spec string) (func() string, error) {
+	var alternatives []func() string
+	for _, alt := range strings.Split(spec, "|") {
+		alt = strings.TrimSpace(alt)
+		if strings.HasPrefix(alt, "/") {
+			alt = "{" + alt + "}"
+		}
+		var parts []func() string
+		last := 0
+		for _, loc := range templatefield.FindAllStringSubmatchIndex(alt, -1) {
+			text := alt[last:loc[0]]
+			parts = append(parts, func() string { return text })
+			gens, err := parseUserFields(rng, map[string]string{"name": alt[loc[2]:loc[3]]})
+			if err != nil {
+				return nil, fmt.Errorf("invalid generator in name %s: %w", spec, err)
+			}
+			gen := gens["name"]
+			parts = append(parts, func() string { return fmt.Sprint(gen()) })
+			last = loc[1]
+		}
+		text := alt[last:]
+		parts = append(parts, func() string { return text })
+		alternatives = append(alternatives, func() string {
+			var b strings.Builder
+			for _, part := range parts {
+				b.WriteString(part())
+			}
+			return b.String()
+		})
+	}
+	return func() string { return alternatives[rng.Intn(len(alternatives))]() }, nil
+}
+
 type Fielder struct {
-	fields map[string]func() any
-	names  []string
+	rng         Rng
+	fields      map[string]func() any
+	names       []string
+	operations  map[string]func() string
+	nevents     int
+	eventName   func() string
+	eventFields map[string]func() any
+}
+
+// the names of span events, if none are given
+const defaultEventNames = "cache.miss|retry|checkpoint|db.query|message"
+
+// A SpanEvent is something that happened at a moment during a span.
+type SpanEvent struct {
+	Name   string
+	Time   time.Time
+	Fields map[string]any
 }
 
 // Fielder is an object that takes a name and generates a map of
@@ -519,7 +579,9 @@ type Fielder struct {
 // combining an adjective and a noun and are consistent for a given fielder.
 // The field values are randomly generated.
 // Fielder also includes the process_id.
-func NewFielder(seed string, userFields map[string]string, nextras, nservices int) (*Fielder, error) {
+// Operations optionally maps a level number, a service name, or "*" to a spec
+// for the names of spans at that level or in that service.
+func NewFielder(seed string, userFields map[string]string, operations map[string]string, nextras, nservices int) (*Fielder, error) {
 	rng := NewRng(seed)
 	gens := rng.getValueGenerators()
 	fields, err := parseUserFields(rng, userFields)
@@ -536,13 +598,82 @@ func NewFielder(seed string, userFields map[string]string, nextras, nservices in
 	for i := 0; i < nservices; i++ {
 		names[i] = rng.Choice(spices)
 	}
-	return &Fielder{fields: fields, names: names}, nil
+
+	// parse operations in a consistent order so they consume the rng consistently
+	keys := make([]string, 0, len(operations))
+	for key := range operations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ops := make(map[string]func() string)
+	for _, key := range keys {
+		ops[key], err = parseNameTemplate(rng, operations[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Fielder{rng: rng, fields: fields, names: names, operations: ops}, nil
+}
+
+// SetEvents makes the fielder generate n events for each span. The event names
+// come from a name spec (see parseNameTemplate), and the fields use the same
+// generators as span fields.
+func (f *Fielder) SetEvents(n int, names string, fields map[string]string) error {
+	if names == "" {
+		names = defaultEventNames
+	}
+	var err error
+	if f.eventName, err = parseNameTemplate(f.rng, names); err != nil {
+		return err
+	}
+	if f.eventFields, err = parseUserFields(f.rng, fields); err != nil {
+		return err
+	}
+	f.nevents = n
+	return nil
+}
+
+// GetEvents returns the events for a span: the ones in its plan, and generated
+// ones at random times during it, in time order.
+func (f *Fielder) GetEvents(span *SpanPlan) []SpanEvent {
+	if f.nevents == 0 {
+		return span.Events
+	}
+	events := make([]SpanEvent, f.nevents, f.nevents+len(span.Events))
+	for i := 0; i < f.nevents; i++ {
+		fields := make(map[string]any, len(f.eventFields))
+		for k, v := range f.eventFields {
+			fields[k] = v()
+		}
+		offset := time.Duration(f.rng.rng.Int63n(int64(span.Duration()) + 1))
+		events[i] = SpanEvent{Name: f.eventName(), Time: span.Start.Add(offset), Fields: fields}
+	}
+	events = append(events, span.Events...)
+	sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
 }
 
 func (f *Fielder) GetServiceName(n int) string {
 	return f.names[n%len(f.names)]
 }
 
+// GetSpanName returns the service and span name for a span in the given
+// service at the given level. Without any operations, the span is just named
+// for its service, and the service is left empty. Otherwise the name comes from
+// the operations for the service, the level, or "*", in that order, falling
+// back to the service name.
+func (f *Fielder) GetSpanName(service string, level int) (string, string) {
+	if len(f.operations) == 0 {
+		return "", service
+	}
+	for _, key := range []string{service, strconv.Itoa(level), "*"} {
+		if op, ok := f.operations[key]; ok {
+			return service, op()
+		}
+	}
+	return service, service
+}
+
 // Searches for a field name that includes a level marker.
 // These markers look like "1.fieldname" and are used to
 // indicate that the field should be included at a specific
@@ -584,20 +715,25 @@ func (f *Fielder) AddFields(span trace.Span, count int64, level int) {
 		if !ok {
 			continue
 		}
-		switch v := val().(type) {
-		case int64:
-			attrs = append(attrs, attribute.Int64(key, v))
-		case uint64:
-			attrs = append(attrs, attribute.Int64(key, int64(v)))
-		case float64:
-			attrs = append(attrs, attribute.Float64(key, v))
-		case string:
-			attrs = append(attrs, attribute.String(key, v))
-		case bool:
-			attrs = append(attrs, attribute.Bool(key, v))
-		default:
-			panic(fmt.Sprintf("unknown type %T for %s -- implementation error in fielder.go", v, key))
-		}
+		attrs = append(attrs, attributeOf(key, val()))
 	}
 	span.SetAttributes(attrs...)
 }
+
+// attributeOf converts a generated field value to an OTel attribute.
+func attributeOf(key string, val any) attribute.KeyValue {
+	switch v := val.(type) {
+	case int64:
+		return attribute.Int64(key, v)
+	case uint64:
+		return attribute.Int64(key, int64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	default:
+		panic(fmt.Sprintf("unknown type %T for %s -- implementation error in fielder.go", v, key))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -64,3 +65,80 @@ func BenchmarkPeriodicEligibility(b *testing.B) {
 		}
 	}
 }
+
+func Test_parseNameTemplate(t *testing.T) {
+	rng := NewRng("hello")
+	tests := []struct {
+		spec  string
+		match string
+	}{
+		{"checkout", `^checkout$`},
+		{"login|logout", `^(login|logout)$`},
+		{"/sw4", `^[a-z]+-[a-z]+$`},
+		{"GET {/up3,5}", `^GET /[a-z]+/[a-z]+$`},
+		{"{/sx4}-{/i10}", `^[0-9a-f]{4}-[0-9]+$`},
+	}
+	for _, tt := range tests {
+		gen, err := parseNameTemplate(rng, tt.spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.spec, err)
+		}
+		for i := 0; i < 10; i++ {
+			if name := gen(); !regexp.MustCompile(tt.match).MatchString(name) {
+				t.Errorf("%s: generated %q, want match for %s", tt.spec, name, tt.match)
+			}
+		}
+	}
+	if _, err := parseNameTemplate(rng, "GET {/zz}"); err == nil {
+		t.Errorf("expected error for invalid generator")
+	}
+}
+
+func Test_GetSpanName(t *testing.T) {
+	f, err := NewFielder("hello", nil, map[string]string{"1": "one", "cart": "GetCart", "*": "other"}, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		service, name string
+		level         int
+	}{{"cart", "GetCart", 1}, {"thyme", "one", 1}, {"thyme", "other", 2}} {
+		service, name := f.GetSpanName(tt.service, tt.level)
+		if service != tt.service || name != tt.name {
+			t.Errorf("GetSpanName(%s, %d) = %s, %s, want %s", tt.service, tt.level, service, name, tt.name)
+		}
+	}
+}
+
+func Test_GetEvents(t *testing.T) {
+	f, err := NewFielder("hello", nil, nil, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := f.GetEvents(&SpanPlan{}); events != nil {
+		t.Errorf("expected no events without SetEvents, got %v", events)
+	}
+	if err := f.SetEvents(5, "retry|timeout", map[string]string{"attempt": "/i10"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	span := &SpanPlan{Start: start, End: start.Add(100 * time.Millisecond)}
+	events := f.GetEvents(span)
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	for i, ev := range events {
+		if ev.Name != "retry" && ev.Name != "timeout" {
+			t.Errorf("unexpected event name %s", ev.Name)
+		}
+		if ev.Time.Before(span.Start) || ev.Time.After(span.End) {
+			t.Errorf("event %d at %v is outside its span", i, ev.Time)
+		}
+		if i > 0 && ev.Time.Before(events[i-1].Time) {
+			t.Errorf("event %d is out of order", i)
+		}
+		if _, ok := ev.Fields["attempt"]; !ok {
+			t.Errorf("event %d is missing its field", i)
+		}
+	}
+}
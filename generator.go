@@ -4,90 +4,110 @@ import (
 	"context"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"pgregory.net/rand"
 )
 
-// A Generator generates traces and sends the individual spans to the spans channel. Its
-// Generate method should be run in a goroutine, and generates a single trace,
-// taking opts.Duration to do so. Its TPS method returns the number of traces
-// per second it is currently generating.
+// A Generator generates traces and sends the individual spans to its sender. Its
+// Generate method should be run in a goroutine, and keeps starting traces until
+// the stop channel is closed or its schedule is complete; it never closes stop
+// itself. Its TPS method returns the number of traces per second it is currently
+// trying to generate.
 type Generator interface {
 	Generate(opts *Options, wg *sync.WaitGroup, stop chan struct{}, counter chan int64)
 	TPS() float64
 }
 
-type GeneratorState int
-
-const (
-	Starting GeneratorState = iota
-	Running
-	Stopping
-)
-
+// A TraceGenerator plans and sends the traces for a single scenario. It is
+// driven by a ScenarioMix, which decides when each trace starts.
 type TraceGenerator struct {
-	depth      int
-	nspans     int
-	duration   time.Duration
-	getFielder func() *Fielder
-	chans      []chan struct{}
-	mut        sync.RWMutex
-	log        Logger
-	tracer     Sender
+	name          string
+	depth         int
+	nspans        int
+	duration      time.Duration
+	timing        string
+	topology      *Topology
+	replay        *Replay
+	latency       *LatencyModel
+	errors        *ErrorModel
+	kinds         bool
+	fireAndForget float64
+	newTraces     float64
+	batchLinks    int
+	backfill      bool
+	fielders      sync.Pool
+	pending       sync.WaitGroup
+	traces        atomic.Int64
+	spans         atomic.Int64
+	log           Logger
+	tracer        Sender
 }
 
-// make sure it implements Generator
-var _ Generator = (*TraceGenerator)(nil)
-
-func NewTraceGenerator(tsender Sender, getFielder func() *Fielder, log Logger, opts *Options) *TraceGenerator {
-	chans := make([]chan struct{}, 0)
+func NewTraceGenerator(name string, tsender Sender, getFielder func() *Fielder, log Logger, opts *Options) *TraceGenerator {
+	latency, err := NewLatencyModel(opts.Format.Latencies)
+	if err != nil {
+		log.Fatal("invalid latency: %s\n", err)
+	}
+	errors, err := NewErrorModel(opts.Format.ErrorRates, opts.Format.ErrorPropagate)
+	if err != nil {
+		log.Fatal("invalid error rates: %s\n", err)
+	}
 	return &TraceGenerator{
-		depth:      opts.Format.Depth,
-		nspans:     opts.Format.NSpans,
-		duration:   opts.Format.TraceTime,
-		getFielder: getFielder,
-		chans:      chans,
-		log:        log,
-		tracer:     tsender,
+		name:          name,
+		depth:         opts.Format.Depth,
+		nspans:        opts.Format.NSpans,
+		duration:      opts.Format.TraceTime,
+		timing:        opts.Format.Timing,
+		topology:      opts.Topology,
+		replay:        opts.replay,
+		latency:       latency,
+		errors:        errors,
+		kinds:         opts.Format.SpanKinds,
+		fireAndForget: opts.Format.FireAndForget,
+		newTraces:     opts.Format.NewTraces,
+		batchLinks:    opts.Format.BatchLinks,
+		backfill:      opts.Quantity.Backfill > 0,
+		fielders:      sync.Pool{New: func() any { return getFielder() }},
+		log:           log,
+		tracer:        tsender,
 	}
 }
 
-// generate_spans generates a list of spans with the given depth and spancount
-// it is recursive and expects spans[0] to be the root span
-// - level is the current depth of this span where 0 is the root span
+// plan_spans plans the children of parent with the given depth and spancount.
+// It is recursive, and returns the time at which the last of the children ends.
 // - depth is the maximum depth (nesting level) of a trace -- how much deeper this trace will go
 // - nspans is the number of spans in a trace.
-// - numOfSpansGenerated is a pointer to the number of spans generated so far. This is only used for debugging.
+// - start is the time at which the first child may start, and timeRemaining
+// is the time available for all of them.
 // If nspans is less than depth, the trace will be truncated at nspans.
 // If nspans is greater than depth, some of the children will have siblings.
-func (s *TraceGenerator) generate_spans(ctx context.Context, fielder *Fielder, level int, depth int, nspans int, timeRemaining time.Duration) int {
+func (s *TraceGenerator) plan_spans(parent *SpanPlan, fielder *Fielder, depth int, nspans int, start time.Time, timeRemaining time.Duration) time.Time {
 	if timeRemaining <= 0 {
-		return 0
+		return start
 	}
 
 	if nspans == 0 {
-		// if there's still time remaining, sleep for the remainder of the time
-		time.Sleep(timeRemaining)
-		return 0
+		// if there's still time remaining, the parent uses up the remainder of the time
+		return start.Add(timeRemaining)
 	}
 
 	// if we are at the bottom of the tree, we need to generate all the spans
 	if depth == 0 && nspans > 0 {
 		durationRemaining := time.Duration(rand.Intn(int(math.Ceil((float64(timeRemaining) / float64(nspans+1))))))
-		spansCreated := 0
+		t := start
 		for i := 0; i < nspans; i++ {
 			durationThisSpan := durationRemaining / time.Duration(nspans-i)
 			durationRemaining -= durationThisSpan
-			time.Sleep(durationThisSpan / 2)
-			_, span := s.tracer.CreateSpan(ctx, fielder.GetServiceName(depth), level, fielder)
-			spansCreated++
-
-			time.Sleep(durationThisSpan / 2)
-			span.Send()
+			service, name := fielder.GetSpanName(fielder.GetServiceName(depth), parent.Level+1)
+			span := parent.addChild(name, t.Add(durationThisSpan/2))
+			span.Service = service
+			span.End = t.Add(durationThisSpan)
+			t = span.End
 		}
 
-		return spansCreated
+		return t
 	}
 
 	spansAtThisLevel := 1
@@ -120,143 +140,149 @@ func (s *TraceGenerator) generate_spans(ctx context.Context, fielder *Fielder, l
 	durationRemaining := time.Duration(rand.Intn(int(math.Ceil((float64(timeRemaining) / float64(spansAtThisLevel+1))))))
 	durationPerChild := (timeRemaining - durationRemaining) / time.Duration(spansAtThisLevel)
 
-	spansCreated := 0
+	t := start
 	for i := 0; i < spansAtThisLevel; i++ {
 		durationThisSpan := durationRemaining / time.Duration(spansAtThisLevel-i)
 		durationRemaining -= durationThisSpan
-		time.Sleep(durationThisSpan / 2)
-		childctx, span := s.tracer.CreateSpan(ctx, fielder.GetServiceName(depth), level, fielder)
-		spansCreated++
+		service, name := fielder.GetSpanName(fielder.GetServiceName(depth), parent.Level+1)
+		span := parent.addChild(name, t.Add(durationThisSpan/2))
+		span.Service = service
 
 		nextDepth := depth - 1
 		nextSpanCount := spancountsPerSpanAtThisLevel[i] - 1
-		childSpansCreated := s.generate_spans(childctx, fielder, level+1, nextDepth, nextSpanCount, durationPerChild)
-		time.Sleep(durationThisSpan / 2)
-		spansCreated += childSpansCreated
-		span.Send()
+		childrenEnd := s.plan_spans(span, fielder, nextDepth, nextSpanCount, span.Start, durationPerChild)
+		span.End = childrenEnd.Add(durationThisSpan / 2)
+		t = span.End
 	}
 
-	return spansCreated
+	return t
 }
 
-func (s *TraceGenerator) generate_root(fielder *Fielder, count int64, depth int, nspans int, timeRemaining time.Duration) {
-	ctx := context.Background()
-	ctx, root := s.tracer.CreateTrace(ctx, fielder.GetServiceName(depth), fielder, count)
+// plan_root plans a complete trace starting at the given time.
+func (s *TraceGenerator) plan_root(fielder *Fielder, depth int, nspans int, start time.Time, timeRemaining time.Duration) *SpanPlan {
+	service, name := fielder.GetSpanName(fielder.GetServiceName(depth), 0)
+	root := newRootPlan(name, start)
+	root.Service = service
 	thisSpanDuration := time.Duration(rand.Intn(int(timeRemaining) / (nspans + 1)))
 	childDuration := (timeRemaining - thisSpanDuration)
 
-	now := time.Now()
-	time.Sleep(thisSpanDuration / 2)
-	totalSpanCreated := s.generate_spans(ctx, fielder, 1, depth-1, nspans-1, childDuration)
-	time.Sleep(thisSpanDuration / 2)
-	totalSpanCreated++
-	root.Send()
-	s.log.Debug("generated %d spans within %v\n", totalSpanCreated, time.Since(now))
+	childrenEnd := s.plan_spans(root, fielder, depth-1, nspans-1, start.Add(thisSpanDuration/2), childDuration)
+	root.End = childrenEnd.Add(thisSpanDuration / 2)
+	return root
 }
 
-// generator is a single goroutine that generates traces and sends them to the spans channel.
-// It runs until the stop channel is closed.
-// The trace time is determined by the duration, and as soon as one trace is sent the next one is started.
-func (s *TraceGenerator) generator(wg *sync.WaitGroup, counter chan int64) {
-	s.mut.Lock()
-	depth := s.depth
-	nspans := s.nspans
-	duration := s.duration
-	stop := make(chan struct{})
-	s.chans = append(s.chans, stop)
-	s.mut.Unlock()
-
-	ticker := time.NewTicker(duration)
-	fielder := s.getFielder()
-	defer wg.Done()
-
-	for {
-		select {
-		case <-stop:
-			ticker.Stop()
-			return
-		case <-ticker.C:
-			// generate a trace if we haven't been stopped by the counter
-			select {
-			case count := <-counter:
-				s.generate_root(fielder, count, depth, nspans, duration)
-			default:
-				// do nothing, we're done, and the stop will be caught by the outer select
-			}
+// emit creates and sends a planned span and all of its children. In realtime
+// mode it sleeps so that each span is created and sent at its planned time;
+// otherwise it sends everything immediately, relying on the timestamps in the
+// plan. The count is only used for the root span.
+func (s *TraceGenerator) emit(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64, realtime bool) {
+	if realtime {
+		time.Sleep(time.Until(span.Start))
+	}
+	var sendable Sendable
+	if span.IsRoot() {
+		ctx, sendable = s.tracer.CreateTrace(ctx, span, fielder, count)
+	} else {
+		ctx, sendable = s.tracer.CreateSpan(ctx, span, fielder)
+	}
+	for _, child := range span.Children {
+		if realtime && child.Detached {
+			// the parent doesn't wait for it, so neither do we
+			s.emitDetached(ctx, child, 0, realtime)
+			continue
 		}
+		s.emit(ctx, child, fielder, 0, realtime)
+	}
+	if realtime {
+		time.Sleep(time.Until(span.End))
 	}
+	sendable.Send()
 }
 
-func (s *TraceGenerator) Generate(opts *Options, wg *sync.WaitGroup, stop chan struct{}, counter chan int64) {
-	defer wg.Done()
-	ngenerators := float64(opts.Quantity.TPS) / s.TPS()
-	uSgeneratorInterval := float64(opts.Quantity.RampTime.Microseconds()) / ngenerators
-	generatorInterval := time.Duration(uSgeneratorInterval) * time.Microsecond
+// emitDetached emits a span in its own goroutine with its own fielder.
+func (s *TraceGenerator) emitDetached(ctx context.Context, span *SpanPlan, count int64, realtime bool) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		fielder := s.fielders.Get().(*Fielder)
+		defer s.fielders.Put(fielder)
+		s.emit(ctx, span, fielder, count, realtime)
+	}()
+}
 
-	s.log.Info("ngenerators: %f interval: %s\n", ngenerators, generatorInterval)
-	state := Starting
+// generate_trace generates a single trace starting at the given time. It is
+// called by the scheduler in its own goroutine, so it borrows a fielder from the
+// pool for the trace's lifetime. Some shapes of trace split off more traces,
+// which are sent along with it.
+func (s *TraceGenerator) generate_trace(count int64, start time.Time) {
+	fielder := s.fielders.Get().(*Fielder)
+	defer s.fielders.Put(fielder)
 
-	ticker := time.NewTicker(generatorInterval)
-	defer ticker.Stop()
+	now := time.Now()
+	var root *SpanPlan
+	switch {
+	case s.replay != nil:
+		root = s.replay.PlanTrace(start)
+	case s.topology != nil:
+		root = s.topology.PlanTrace(start, s.duration)
+	default:
+		root = s.plan_root(fielder, s.depth, s.nspans, start, s.duration)
+	}
+	if s.latency != nil {
+		s.latency.Apply(root)
+	}
+	if s.fireAndForget > 0 {
+		DetachSpans(root, s.fireAndForget, s.newTraces)
+	}
+	if s.batchLinks > 0 {
+		MakeBatchConsumer(root, s.batchLinks)
+	}
+	s.errors.Apply(root)
+	if s.kinds {
+		AddSpanKinds(root)
+	}
+	roots := append([]*SpanPlan{root}, SplitTraces(root)...)
+	if s.batchLinks == 0 {
+		// batch consumers link to producers, not to each other
+		recent.add(root)
+	}
 
-	// Create a long timer but stop it immediately so that we have a valid channel.
-	// We'll Reset it in the Starting state if they specified a max time.
-	stopTimer := time.NewTimer(time.Hour)
-	stopTimer.Stop()
+	nspans := 0
+	end := root.End
+	for _, r := range roots {
+		nspans += r.Count()
+		end = maxTime(end, r.LastEnd())
+	}
+	s.traces.Add(int64(len(roots)))
+	s.spans.Add(int64(nspans))
 
-	for {
-		select {
-		case <-stop:
-			s.log.Info("stopping generators from stop signal\n")
-			state = Stopping
-			s.mut.Lock()
-			for _, ch := range s.chans {
-				close(ch)
-			}
-			s.mut.Unlock()
-			return
-		case <-ticker.C:
-			switch state {
-			case Starting:
-				if len(s.chans) >= int(ngenerators+0.5) { // make sure we don't get bit by floating point rounding
-					s.log.Info("all generators started, switching to Running state\n")
-					// if they want a timer, start it now
-					if opts.Quantity.RunTime > 0 {
-						// could have used AfterFunc, but we're already in a goroutine with a select
-						// and it would have required a mutex to protect the state
-						stopTimer.Reset(opts.Quantity.RunTime)
-						defer stopTimer.Stop()
-					}
-					// and change to run state
-					state = Running
-				} else {
-					s.log.Debug("starting new generator\n")
-					wg.Add(1)
-					go s.generator(wg, counter)
-				}
-			case Running:
-				// do nothing
-			case Stopping:
-				s.mut.Lock()
-				if len(s.chans) == 0 {
-					s.mut.Unlock()
-					close(stop)
-					return
-				}
-				s.log.Debug("killing off a generator\n")
-				close(s.chans[0])
-				s.chans = s.chans[1:]
-				s.mut.Unlock()
-			}
-		case <-stopTimer.C:
-			s.log.Info("stopping generators from timer\n")
-			state = Stopping
+	// only the original trace gets the count
+	counts := make([]int64, len(roots))
+	counts[0] = count
+	switch {
+	case s.backfill:
+		// the start time comes from the simulated clock, so send it all right away
+		for i, r := range roots {
+			s.emit(context.Background(), r, fielder, counts[i], false)
+		}
+	case s.timing == "synthetic":
+		// backdate the traces so that the last span ends now, then send them all at once
+		for i, r := range roots {
+			r.Shift(-end.Sub(start))
+			s.emit(context.Background(), r, fielder, counts[i], false)
+		}
+	case s.timing == "deferred":
+		// send the traces all at once when they end; until then the trace keeps
+		// its in-flight slot, so --maxinflight limits how many are waiting
+		time.Sleep(time.Until(end))
+		for i, r := range roots {
+			s.emit(context.Background(), r, fielder, counts[i], false)
 		}
+	default:
+		for i, r := range roots[1:] {
+			s.emitDetached(context.Background(), r, counts[i+1], true)
+		}
+		s.emit(context.Background(), root, fielder, count, true)
 	}
-}
-
-func (s *TraceGenerator) TPS() float64 {
-	s.mut.RLock()
-	defer s.mut.RUnlock()
-	return 1.0 / s.duration.Seconds()
+	s.log.Debug("generated %d spans within %v\n", nspans, time.Since(now))
 }
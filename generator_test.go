@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func checkPlan(t *testing.T, span *SpanPlan, parent *SpanPlan) {
+	t.Helper()
+	if span.End.Before(span.Start) {
+		t.Errorf("span %s ends before it starts", span.SpanID)
+	}
+	if parent != nil {
+		if span.TraceID != parent.TraceID {
+			t.Errorf("span %s has trace ID %s, parent has %s", span.SpanID, span.TraceID, parent.TraceID)
+		}
+		if span.ParentID != parent.SpanID {
+			t.Errorf("span %s has parent ID %s, want %s", span.SpanID, span.ParentID, parent.SpanID)
+		}
+		if span.Level != parent.Level+1 {
+			t.Errorf("span %s is at level %d, parent is at %d", span.SpanID, span.Level, parent.Level)
+		}
+		if span.Start.Before(parent.Start) || (span.End.After(parent.End) && !span.Detached) {
+			t.Errorf("span %s (%v-%v) is outside its parent (%v-%v)", span.SpanID, span.Start, span.End, parent.Start, parent.End)
+		}
+	}
+	for _, child := range span.Children {
+		checkPlan(t, child, span)
+	}
+}
+
+func Test_PlanRoot(t *testing.T) {
+	fielder, err := NewFielder("test", nil, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := &TraceGenerator{}
+	start := time.Now()
+	for _, shape := range []struct{ depth, nspans int }{{1, 1}, {3, 3}, {4, 20}, {10, 5}} {
+		root := tg.plan_root(fielder, shape.depth, shape.nspans, start, time.Second)
+		if !root.IsRoot() || root.Start != start {
+			t.Errorf("bad root span %+v", root)
+		}
+		if root.Count() > shape.nspans {
+			t.Errorf("depth %d nspans %d: planned %d spans", shape.depth, shape.nspans, root.Count())
+		}
+		if root.Duration() > time.Second {
+			t.Errorf("depth %d nspans %d: trace took %v", shape.depth, shape.nspans, root.Duration())
+		}
+		checkPlan(t, root, nil)
+
+		end := root.End
+		root.Shift(-root.Duration())
+		if !root.End.Equal(start) || !root.Start.Equal(start.Add(-end.Sub(start))) {
+			t.Errorf("shifted root is %v-%v", root.Start, root.End)
+		}
+		checkPlan(t, root, nil)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envRef matches a reference to an environment variable in a header value.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces each ${NAME} in s with the value of the environment
+// variable NAME, so that secrets can be kept out of config files and command
+// lines. It's an error for a variable to be unset, so that a missing secret is
+// noticed before anything is sent without it.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// expandHeaders expands the environment variables in the values of headers, in
+// place.
+func expandHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		expanded, err := expandEnv(v)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		headers[k] = expanded
+	}
+	return nil
+}
+
+// ResolveHeaders expands the environment variables in the headers given with
+// --header, and adds an Authorization header for --bearertoken or --basicauth.
+func ResolveHeaders(opts *Options) error {
+	if err := expandHeaders(opts.Telemetry.Headers); err != nil {
+		return err
+	}
+	bearer, err := expandEnv(opts.Telemetry.BearerToken)
+	if err != nil {
+		return fmt.Errorf("bearer token: %w", err)
+	}
+	basic, err := expandEnv(opts.Telemetry.BasicAuth)
+	if err != nil {
+		return fmt.Errorf("basic auth: %w", err)
+	}
+	var auth string
+	switch {
+	case bearer != "" && basic != "":
+		return fmt.Errorf("only one of bearertoken and basicauth can be given")
+	case bearer != "":
+		auth = "Bearer " + bearer
+	case basic != "" && !strings.Contains(basic, ":"):
+		return fmt.Errorf("basic auth must be given as USER:PASSWORD")
+	case basic != "":
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(basic))
+	default:
+		return nil
+	}
+	for k := range opts.Telemetry.Headers {
+		if http.CanonicalHeaderKey(k) == "Authorization" {
+			return fmt.Errorf("an Authorization header can't be given as well as a bearer token or basic auth")
+		}
+	}
+	if opts.Telemetry.Headers == nil {
+		opts.Telemetry.Headers = make(map[string]string)
+	}
+	opts.Telemetry.Headers["Authorization"] = auth
+	return nil
+}
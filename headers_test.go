@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func Test_expandEnv(t *testing.T) {
+	t.Setenv("LOADGEN_TEST_TOKEN", "s3cret")
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"Bearer ${LOADGEN_TEST_TOKEN}", "Bearer s3cret", false},
+		{"${LOADGEN_TEST_TOKEN}:${LOADGEN_TEST_TOKEN}", "s3cret:s3cret", false},
+		{"$LOADGEN_TEST_TOKEN costs $5", "$LOADGEN_TEST_TOKEN costs $5", false},
+		{"${LOADGEN_TEST_UNSET}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandEnv(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_ResolveHeaders(t *testing.T) {
+	t.Setenv("LOADGEN_TEST_PASSWORD", "hunter2")
+	opts := &Options{}
+	opts.Telemetry.BasicAuth = "loadgen:${LOADGEN_TEST_PASSWORD}"
+	if err := ResolveHeaders(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := opts.Telemetry.Headers["Authorization"]; got != "Basic bG9hZGdlbjpodW50ZXIy" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+
+	opts = &Options{}
+	opts.Telemetry.BearerToken = "abc"
+	opts.Telemetry.Headers = map[string]string{"authorization": "Bearer xyz"}
+	if err := ResolveHeaders(opts); err == nil {
+		t.Errorf("expected an error for two Authorization headers")
+	}
+	opts.Telemetry.Headers = nil
+	opts.Telemetry.BasicAuth = "loadgen:pw"
+	if err := ResolveHeaders(opts); err == nil {
+		t.Errorf("expected an error for both a bearer token and basic auth")
+	}
+}
+
+func Test_SenderOTel_HTTPPathAndHeaders(t *testing.T) {
+	var mut sync.Mutex
+	var paths []string
+	var headers []http.Header
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		paths = append(paths, r.URL.Path)
+		headers = append(headers, r.Header.Clone())
+		mut.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range []struct {
+		path, otlpPath, want string
+	}{
+		{"", "", "/v1/traces"},
+		{"/otlp", "", "/otlp/v1/traces"},
+		{"", "/api/v2/otlp/traces", "/api/v2/otlp/traces"},
+	} {
+		paths, headers = nil, nil
+		opts := &Options{}
+		opts.Telemetry.Dataset = "loadgen"
+		opts.Telemetry.Insecure = true
+		opts.Telemetry.BearerToken = "abc"
+		opts.Telemetry.Headers = map[string]string{"X-Scope-OrgID": "tenant1"}
+		opts.Output.Sender = "otel"
+		opts.Output.Protocol = "http"
+		opts.Output.OTLPPath = tt.otlpPath
+		if err := ResolveHeaders(opts); err != nil {
+			t.Fatal(err)
+		}
+		opts.apihost = parseHost(NewLogger(0), host.String()+tt.path, true)
+		sendSpans(t, NewSenderOTel(NewLogger(0), opts), 0)
+
+		mut.Lock()
+		if len(paths) != 1 || paths[0] != tt.want {
+			t.Errorf("expected a request to %s, got %v", tt.want, paths)
+		} else {
+			h := headers[0]
+			if h.Get("Authorization") != "Bearer abc" || h.Get("X-Scope-OrgID") != "tenant1" || h.Get("X-Honeycomb-Team") != "" {
+				t.Errorf("unexpected headers %v", h)
+			}
+		}
+		mut.Unlock()
+	}
+}
+
+func Test_otlpHeaders(t *testing.T) {
+	log := NewLogger(0)
+	opts := &Options{}
+	opts.Telemetry.APIKey = "key"
+	opts.Telemetry.Dataset = "loadgen"
+	opts.apihost = parseHost(log, "https://api.eu1.honeycomb.io", false)
+	opts.Telemetry.Headers = map[string]string{"x-honeycomb-dataset": "classic"}
+	want := map[string]string{"x-honeycomb-team": "key", "x-honeycomb-dataset": "classic"}
+	if got := otlpHeaders(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// the API key only goes to Honeycomb, unless it's given as a header
+	opts.apihost = parseHost(log, "https://otlp.example.com", false)
+	if got := otlpHeaders(opts); !reflect.DeepEqual(got, map[string]string{"x-honeycomb-dataset": "classic"}) {
+		t.Errorf("expected no API key header, got %v", got)
+	}
+	opts.Telemetry.Headers = map[string]string{"x-honeycomb-team": "other"}
+	if got := otlpHeaders(opts); !reflect.DeepEqual(got, opts.Telemetry.Headers) {
+		t.Errorf("expected the API key header that was given, got %v", got)
+	}
+}
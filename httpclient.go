@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how long an HTTP request can take, if --exporttimeout isn't set
+const defaultHTTPTimeout = 30 * time.Second
+
+// the delay before the first retry of a failed request; it doubles for each retry
+const retryDelay = 100 * time.Millisecond
+
+// newHTTPClient returns a client for the senders that post to an HTTP API
+// themselves, rather than through an SDK. It keeps enough idle connections
+// open for the given number of concurrent requests, and uses the TLS options.
+func newHTTPClient(opts *Options, concurrency int) *http.Client {
+	timeout := opts.Output.ExportTimeout
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	if opts.tls != nil {
+		transport.TLSClientConfig = tlsConfig(opts)
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// userAgent is sent with every request made by an httpPoster.
+var userAgent = ResourceLibrary + "/" + ResourceVersion
+
+// An httpPoster posts request bodies to a URL with the given headers, and any
+// given with --header, after compressing them, and retries requests that fail
+// in ways that might not happen again. It counts the bytes it posts.
+type httpPoster struct {
+	log     Logger
+	client  *http.Client
+	url     string
+	headers map[string]string
+	bytes   byteCounter
+	retries int
+}
+
+func newHTTPPoster(log Logger, opts *Options, url string, headers map[string]string, compression string) *httpPoster {
+	all := make(map[string]string, len(headers)+len(opts.Telemetry.Headers))
+	for k, v := range headers {
+		all[k] = v
+	}
+	for k, v := range opts.Telemetry.Headers {
+		all[k] = v
+	}
+	p := &httpPoster{
+		log:     log,
+		client:  newHTTPClient(opts, opts.Output.Concurrency),
+		url:     url,
+		headers: all,
+		retries: opts.Output.Retries,
+	}
+	p.bytes.codec = compression
+	return p
+}
+
+// post compresses and posts a body, retrying with exponential backoff if it
+// fails with a network error, a 429 or a 5xx. The caller must close the body
+// of the response it returns.
+func (p *httpPoster) post(body []byte, contentType string) (*http.Response, error) {
+	compressed, err := compress(p.bytes.codec, body)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		p.bytes.add(len(body), len(compressed))
+		resp, err := p.postOnce(compressed, contentType)
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable || attempt >= p.retries {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		delay := retryDelay << attempt
+		p.log.Debug("retrying request to %s in %v after attempt %d failed\n", p.url, delay, attempt+1)
+		time.Sleep(delay)
+	}
+}
+
+// checkResponse returns an error for a response whose status isn't 2xx, with
+// the start of its body, which usually says what was wrong.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+func (p *httpPoster) postOnce(body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", userAgent)
+	if p.bytes.codec != "none" {
+		req.Header.Set("Content-Encoding", p.bytes.codec)
+	}
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	return p.client.Do(req)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pgregory.net/rand"
+)
+
+// z99 is the number of standard deviations between the median and the 99th
+// percentile of a normal distribution
+const z99 = 2.3263
+
+// the spread of each mode of a bimodal distribution, as the sigma of a lognormal
+const bimodalSigma = 0.25
+
+// A Distribution generates random span durations.
+type Distribution interface {
+	Sample() time.Duration
+}
+
+type fixedDist time.Duration
+
+func (d fixedDist) Sample() time.Duration {
+	return time.Duration(d)
+}
+
+// lognormalDist is described by its median and 99th percentile, which are easy
+// to read off a latency graph, rather than by mu and sigma.
+type lognormalDist struct {
+	mu, sigma float64
+}
+
+func newLognormal(p50, p99 time.Duration) lognormalDist {
+	mu := math.Log(float64(p50))
+	return lognormalDist{mu: mu, sigma: (math.Log(float64(p99)) - mu) / z99}
+}
+
+func (d lognormalDist) Sample() time.Duration {
+	return time.Duration(math.Exp(d.mu + d.sigma*rand.NormFloat64()))
+}
+
+// gammaDist is described by its mean and standard deviation.
+type gammaDist struct {
+	shape, scale float64
+}
+
+func newGamma(mean, stddev time.Duration) gammaDist {
+	m, s := float64(mean), float64(stddev)
+	return gammaDist{shape: (m / s) * (m / s), scale: s * s / m}
+}
+
+func (d gammaDist) Sample() time.Duration {
+	return time.Duration(sampleGamma(d.shape) * d.scale)
+}
+
+// sampleGamma returns a sample from a gamma distribution with the given shape
+// and a scale of 1, using the method of Marsaglia and Tsang.
+func sampleGamma(shape float64) float64 {
+	if shape < 1 {
+		// boost the shape and correct for it afterwards
+		return sampleGamma(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// histogramDist picks a bucket by weight, then a duration uniformly within it.
+// Each bucket runs from the upper bound of the previous one (or 0) to its own.
+type histogramDist struct {
+	bounds  []time.Duration
+	weights []float64
+	total   float64
+}
+
+func (d histogramDist) Sample() time.Duration {
+	r := rand.Float64() * d.total
+	lower := time.Duration(0)
+	for i, w := range d.weights {
+		if r < w || i == len(d.weights)-1 {
+			return lower + time.Duration(rand.Float64()*float64(d.bounds[i]-lower))
+		}
+		r -= w
+		lower = d.bounds[i]
+	}
+	return lower
+}
+
+// bimodalDist is usually fast, but takes a slow path some percentage of the time.
+type bimodalDist struct {
+	fast, slow lognormalDist
+	slowPct    float64
+}
+
+func (d bimodalDist) Sample() time.Duration {
+	if rand.Float64()*100 < d.slowPct {
+		return d.slow.Sample()
+	}
+	return d.fast.Sample()
+}
+
+// ParseDistribution parses a latency spec of the form shape:p1,p2,... See
+// README.md for the list of shapes and their parameters.
+func ParseDistribution(spec string) (Distribution, error) {
+	shape, params, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("latency %s must look like shape:parameters", spec)
+	}
+	args := strings.Split(params, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+
+	// collect the errors from parsing each argument so we only check once
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	dur := func(s string) time.Duration {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			fail(fmt.Errorf("invalid duration %s in latency %s", s, spec))
+		}
+		return d
+	}
+	count := func(n int) bool {
+		if len(args) != n {
+			fail(fmt.Errorf("latency %s needs %d parameters", spec, n))
+			return false
+		}
+		return true
+	}
+
+	var dist Distribution
+	switch strings.TrimSpace(shape) {
+	case "fixed":
+		// fixed:duration
+		if count(1) {
+			dist = fixedDist(dur(args[0]))
+		}
+	case "lognormal":
+		// lognormal:p50,p99
+		if count(2) {
+			p50, p99 := dur(args[0]), dur(args[1])
+			if firstErr == nil && p99 < p50 {
+				fail(fmt.Errorf("p99 must not be less than p50 in latency %s", spec))
+			}
+			dist = newLognormal(p50, p99)
+		}
+	case "gamma":
+		// gamma:mean,stddev
+		if count(2) {
+			dist = newGamma(dur(args[0]), dur(args[1]))
+		}
+	case "histogram":
+		// histogram:bound=weight,bound=weight,... -- bounds in increasing order
+		h := histogramDist{}
+		for _, arg := range args {
+			b, w, ok := strings.Cut(arg, "=")
+			weight, err := strconv.ParseFloat(w, 64)
+			if !ok || err != nil || weight < 0 {
+				fail(fmt.Errorf("histogram bucket %s must look like duration=weight in latency %s", arg, spec))
+				continue
+			}
+			bound := dur(b)
+			if len(h.bounds) > 0 && bound <= h.bounds[len(h.bounds)-1] {
+				fail(fmt.Errorf("histogram buckets must be in increasing order in latency %s", spec))
+			}
+			h.bounds = append(h.bounds, bound)
+			h.weights = append(h.weights, weight)
+			h.total += weight
+		}
+		if firstErr == nil && h.total == 0 {
+			fail(fmt.Errorf("histogram needs a positive weight in latency %s", spec))
+		}
+		dist = h
+	case "bimodal":
+		// bimodal:fast,slow,slowpct -- the medians of the two modes
+		if count(3) {
+			pct, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || pct < 0 || pct > 100 {
+				fail(fmt.Errorf("invalid percentage %s in latency %s", args[2], spec))
+			}
+			fast, slow := dur(args[0]), dur(args[1])
+			dist = bimodalDist{
+				fast:    newLognormal(fast, time.Duration(float64(fast)*math.Exp(bimodalSigma*z99))),
+				slow:    newLognormal(slow, time.Duration(float64(slow)*math.Exp(bimodalSigma*z99))),
+				slowPct: pct,
+			}
+		}
+	default:
+		fail(fmt.Errorf("unknown shape %s in latency %s", shape, spec))
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return dist, nil
+}
+
+// A LatencyModel sets the durations of the spans in a planned trace from
+// distributions chosen by operation, service, or level. Each span's sampled
+// duration is the time it would take on its own; if its children need longer
+// than that, the span is stretched to cover them.
+type LatencyModel struct {
+	dists map[string]Distribution
+}
+
+// NewLatencyModel takes a map of operation names, service names, level numbers,
+// or "*" to latency specs. It returns nil if there are no specs.
+func NewLatencyModel(specs map[string]string) (*LatencyModel, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	m := &LatencyModel{dists: make(map[string]Distribution)}
+	for key, spec := range specs {
+		dist, err := ParseDistribution(spec)
+		if err != nil {
+			return nil, err
+		}
+		m.dists[key] = dist
+	}
+	return m, nil
+}
+
+// lookup returns the distribution for a span, or nil if there isn't one.
+func (m *LatencyModel) lookup(span *SpanPlan) Distribution {
+	for _, key := range []string{span.Name, span.ServiceName(), strconv.Itoa(span.Level), "*"} {
+		if dist, ok := m.dists[key]; ok {
+			return dist
+		}
+	}
+	return nil
+}
+
+// Apply lays out the trace again starting from the root's start time, using the
+// model's distributions. Spans without a distribution keep their planned
+// durations, but are still stretched to cover their children.
+func (m *LatencyModel) Apply(root *SpanPlan) {
+	m.layout(root, root.Start)
+}
+
+// layout sets the duration of a span and the times of its children, starting at
+// start. Children that were planned to start at the same time run in parallel;
+// the others run one after another, with any time left over spread evenly
+// around them. Detached children start in their place, but the span doesn't
+// wait for them.
+func (m *LatencyModel) layout(span *SpanPlan, start time.Time) {
+	d := span.Duration()
+	if dist := m.lookup(span); dist != nil {
+		d = dist.Sample()
+	}
+
+	// group the children that run in parallel, before their times change
+	children := span.Children
+	sort.SliceStable(children, func(i, j int) bool { return children[i].Start.Before(children[j].Start) })
+	var groups [][]*SpanPlan
+	for i, child := range children {
+		if i > 0 && child.Start.Equal(children[i-1].Start) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], child)
+		} else {
+			groups = append(groups, []*SpanPlan{child})
+		}
+	}
+
+	lengths := make([]time.Duration, len(groups))
+	var total time.Duration
+	for i, group := range groups {
+		for _, child := range group {
+			m.layout(child, start)
+			if !child.Detached {
+				lengths[i] = max(lengths[i], child.Duration())
+			}
+		}
+		total += lengths[i]
+	}
+	d = max(d, total)
+	gap := (d - total) / time.Duration(len(groups)+1)
+
+	t := start.Add(gap)
+	for i, group := range groups {
+		for _, child := range group {
+			child.Shift(t.Sub(child.Start))
+		}
+		t = t.Add(lengths[i] + gap)
+	}
+	span.Start = start
+	span.End = start.Add(d)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_ParseDistribution(t *testing.T) {
+	for _, tt := range []struct {
+		spec     string
+		min, max time.Duration // bounds on the median of many samples
+	}{
+		{"fixed:50ms", 50 * time.Millisecond, 50 * time.Millisecond},
+		{"lognormal:40ms,900ms", 30 * time.Millisecond, 50 * time.Millisecond},
+		{"gamma:100ms,10ms", 90 * time.Millisecond, 110 * time.Millisecond},
+		{"histogram:10ms=0,20ms=1", 10 * time.Millisecond, 20 * time.Millisecond},
+		{"bimodal:20ms,800ms,1", 18 * time.Millisecond, 22 * time.Millisecond},
+	} {
+		dist, err := ParseDistribution(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		samples := make([]time.Duration, 1001)
+		for i := range samples {
+			samples[i] = dist.Sample()
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		if median := samples[500]; median < tt.min || median > tt.max {
+			t.Errorf("%s: median %v is outside %v-%v", tt.spec, median, tt.min, tt.max)
+		}
+	}
+	for _, spec := range []string{"fixed", "fixed:0s", "lognormal:900ms,40ms", "histogram:20ms=1,10ms=1", "bimodal:1ms,2ms,200", "normal:1s"} {
+		if _, err := ParseDistribution(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func Test_LatencyModel(t *testing.T) {
+	m, err := NewLatencyModel(map[string]string{"0": "fixed:10ms", "db": "fixed:50ms", "*": "fixed:5ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	root := newRootPlan("frontend", start)
+	cart := root.addChild("cart", start)
+	cart.addChild("db", start)
+	cart.addChild("db", start) // in parallel with the first
+	root.addChild("search", start.Add(time.Millisecond))
+
+	m.Apply(root)
+	checkPlan(t, root, nil)
+	if cart.Duration() != 50*time.Millisecond {
+		t.Errorf("cart should be stretched to cover its parallel children, took %v", cart.Duration())
+	}
+	if root.Duration() != 55*time.Millisecond || !root.Start.Equal(start) {
+		t.Errorf("root should cover its sequential children, took %v", root.Duration())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"pgregory.net/rand"
+)
+
+// the number of recent traces that batch consumers can link to
+const recentTraceCount = 1000
+
+// A SpanLink points from a span to a span in another trace (or elsewhere in the
+// same one) that it is related to, but isn't the child of.
+type SpanLink struct {
+	TraceID trace.TraceID
+	SpanID  trace.SpanID
+}
+
+// DetachSpans makes pct percent of the non-root spans in a trace fire-and-forget
+// spans: their parent doesn't wait for them, so they may finish after it does.
+// Of those, newTracePct percent are also marked to continue in a new trace.
+func DetachSpans(span *SpanPlan, pct, newTracePct float64) {
+	for _, child := range span.Children {
+		if !child.Detached && rand.Float64()*100 < pct {
+			child.Detached = true
+			if end := span.End.Add(randDuration(child.Duration())); end.After(child.End) {
+				child.End = end
+			}
+		}
+		if child.Detached && !child.NewTrace && rand.Float64()*100 < newTracePct {
+			child.NewTrace = true
+		}
+		DetachSpans(child, pct, newTracePct)
+	}
+}
+
+// SplitTraces removes each span marked to continue in a new trace from its
+// parent, and makes it the root of a new trace with a link back to the span
+// that started it, like a message consumer that starts its own trace. It
+// returns the new traces, in the order they start.
+func SplitTraces(root *SpanPlan) []*SpanPlan {
+	var split []*SpanPlan
+	var walk func(span *SpanPlan)
+	walk = func(span *SpanPlan) {
+		children := span.Children[:0]
+		for _, child := range span.Children {
+			if child.NewTrace {
+				child.ParentID = trace.SpanID{}
+				child.setLevel(0)
+				child.setTraceID(newTraceID())
+				child.Links = append(child.Links, SpanLink{TraceID: span.TraceID, SpanID: span.SpanID})
+				split = append(split, child)
+			} else {
+				children = append(children, child)
+			}
+			walk(child)
+		}
+		span.Children = children
+	}
+	walk(root)
+	return split
+}
+
+// setTraceID moves this span and all its descendants to another trace.
+func (s *SpanPlan) setTraceID(id trace.TraceID) {
+	s.TraceID = id
+	for _, child := range s.Children {
+		child.setTraceID(id)
+	}
+}
+
+// LastEnd returns the latest end time of any span in the subtree.
+func (s *SpanPlan) LastEnd() time.Time {
+	end := s.End
+	for _, child := range s.Children {
+		end = maxTime(end, child.LastEnd())
+	}
+	return end
+}
+
+// recentTraces remembers the roots of recent traces from every scenario, so
+// that batch consumers can link to them.
+type recentTraces struct {
+	mut   sync.Mutex
+	links []SpanLink
+	next  int
+}
+
+var recent = &recentTraces{}
+
+func (r *recentTraces) add(span *SpanPlan) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	link := SpanLink{TraceID: span.TraceID, SpanID: span.SpanID}
+	if len(r.links) < recentTraceCount {
+		r.links = append(r.links, link)
+	} else {
+		r.links[r.next] = link
+		r.next = (r.next + 1) % recentTraceCount
+	}
+}
+
+// pick returns up to n links to different recent traces.
+func (r *recentTraces) pick(n int) []SpanLink {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	n = min(n, len(r.links))
+	links := make([]SpanLink, 0, n)
+	for _, i := range rand.Perm(len(r.links))[:n] {
+		links = append(links, r.links[i])
+	}
+	return links
+}
+
+// validateBatchLinks checks that if some traces are batch consumers, there are
+// others for them to link to. Batch consumers never link to each other, so that
+// needs a scenario whose traces aren't batch consumers.
+func validateBatchLinks(opts *Options) error {
+	consumers, producers := opts.Format.BatchLinks > 0 && len(opts.Scenarios) == 0, false
+	for _, sc := range opts.Scenarios {
+		if sc.Options(opts).Format.BatchLinks > 0 {
+			consumers = true
+		} else {
+			producers = true
+		}
+	}
+	if consumers && !producers {
+		return fmt.Errorf("every trace would be a batch consumer, with no traces to link to; use --batchlinks in one of several scenarios")
+	}
+	return nil
+}
+
+// MakeBatchConsumer turns the root of a trace into a consumer that processes a
+// batch of up to n messages, each of which was produced by a recent trace it
+// links to.
+func MakeBatchConsumer(root *SpanPlan, n int) {
+	root.Links = append(root.Links, recent.pick(n)...)
+	root.Kind = trace.SpanKindConsumer
+	root.setAttribute("messaging.operation", "process")
+	root.setAttribute("messaging.batch.message_count", int64(len(root.Links)))
+}
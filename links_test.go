@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_SplitTraces(t *testing.T) {
+	start := time.Now()
+	root := newRootPlan("frontend", start)
+	root.End = start.Add(100 * time.Millisecond)
+	cart := root.addChild("cart", start.Add(10*time.Millisecond))
+	cart.End = start.Add(20 * time.Millisecond)
+	mailer := cart.addChild("mailer", start.Add(15*time.Millisecond))
+	mailer.End = start.Add(16 * time.Millisecond)
+	mailer.addChild("smtp", mailer.Start).End = mailer.End
+
+	DetachSpans(root, 100, 0)
+	if !cart.Detached || !mailer.Detached || mailer.NewTrace {
+		t.Errorf("expected spans to be detached but not split, got %+v", mailer)
+	}
+	if cart.End.Before(root.End) || mailer.End.Before(cart.End) {
+		t.Errorf("detached spans should outlive their parents")
+	}
+	checkPlan(t, root, nil)
+
+	mailer.NewTrace = true
+	split := SplitTraces(root)
+	if len(split) != 1 || split[0] != mailer || len(cart.Children) != 0 {
+		t.Fatalf("unexpected split %v", split)
+	}
+	if !mailer.IsRoot() || mailer.Level != 0 || mailer.TraceID == root.TraceID {
+		t.Errorf("split trace should be a new root, got %+v", mailer)
+	}
+	if len(mailer.Links) != 1 || mailer.Links[0].SpanID != cart.SpanID || mailer.Links[0].TraceID != root.TraceID {
+		t.Errorf("split trace should link to its producer, got %+v", mailer.Links)
+	}
+	checkPlan(t, mailer, nil)
+}
+
+func Test_validateBatchLinks(t *testing.T) {
+	opts := newOptions()
+	opts.Format.BatchLinks = 5
+	if err := ValidateScenarios(opts); err == nil {
+		t.Errorf("expected an error for batch consumers without scenarios")
+	}
+	opts.Scenarios = []*Scenario{{Name: "producer"}, {Name: "consumer"}}
+	if err := ValidateScenarios(opts); err == nil {
+		t.Errorf("expected an error when every scenario has batch consumers")
+	}
+	opts.Format.BatchLinks = 0
+	opts.Scenarios[1].Format.BatchLinks = 5
+	if err := ValidateScenarios(opts); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 type Logger interface {
@@ -16,10 +18,17 @@ type Logger interface {
 
 type logger struct {
 	verbosity int
+	out       io.Writer
 }
 
 func NewLogger(verbosity int) Logger {
-	return &logger{verbosity: verbosity}
+	return &logger{verbosity: verbosity, out: os.Stdout}
+}
+
+// NewStderrLogger returns a logger that writes everything to stderr, for when
+// stdout is taken by a sender's output.
+func NewStderrLogger(verbosity int) Logger {
+	return &logger{verbosity: verbosity, out: os.Stderr}
 }
 
 func (l *logger) Error(format string, v ...interface{}) {
@@ -32,23 +41,59 @@ func (l *logger) Fatal(format string, v ...interface{}) {
 }
 
 func (l *logger) Printf(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
+	fmt.Fprintf(l.out, format, v...)
 }
 
 func (l *logger) Warn(format string, v ...interface{}) {
 	if l.verbosity >= 1 {
-		fmt.Printf(format, v...)
+		fmt.Fprintf(l.out, format, v...)
 	}
 }
 
 func (l *logger) Info(format string, v ...interface{}) {
 	if l.verbosity >= 2 {
-		fmt.Printf(format, v...)
+		fmt.Fprintf(l.out, format, v...)
 	}
 }
 
 func (l *logger) Debug(format string, v ...interface{}) {
 	if l.verbosity >= 3 {
-		fmt.Printf(format, v...)
+		fmt.Fprintf(l.out, format, v...)
 	}
 }
+
+// prefixLogger adds a prefix to everything logged through it, so that the
+// messages from one of several senders can be told apart.
+type prefixLogger struct {
+	Logger
+	prefix string
+}
+
+// WithPrefix returns a logger that adds prefix to the start of every message.
+func WithPrefix(log Logger, prefix string) Logger {
+	return prefixLogger{Logger: log, prefix: strings.ReplaceAll(prefix, "%", "%%")}
+}
+
+func (l prefixLogger) Error(format string, v ...interface{}) {
+	l.Logger.Error(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Fatal(format string, v ...interface{}) {
+	l.Logger.Fatal(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Warn(format string, v ...interface{}) {
+	l.Logger.Warn(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Info(format string, v ...interface{}) {
+	l.Logger.Info(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Debug(format string, v ...interface{}) {
+	l.Logger.Debug(l.prefix+format, v...)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,32 +22,87 @@ import (
 var ResourceLibrary = "loadgen"
 var ResourceVersion = "dev"
 
+// FormatOptions describe the shape of the traces; they are a separate type so
+// that each scenario can have its own.
+type FormatOptions struct {
+	Depth          int                `long:"depth" description:"the nesting depth of each trace" default:"3"`
+	NSpans         int                `long:"nspans" description:"the total number of spans in a trace" default:"3"`
+	Extra          int                `long:"extra" description:"the number of random fields in a span beyond the standard ones" default:"0" yaml:",omitempty"`
+	TraceTime      time.Duration      `long:"tracetime" description:"the duration of a trace" default:"1s"`
+	Operations     map[string]string  `long:"operation" description:"span names for a level or service, as LEVEL:SPEC or SERVICE:SPEC; may be repeated (see README)" yaml:",omitempty"`
+	Latencies      map[string]string  `long:"latency" description:"the distribution of span durations for an operation, service or level, as KEY:SHAPE:PARAMS; may be repeated (see README)" yaml:",omitempty"`
+	ErrorRates     map[string]float64 `long:"errorrate" description:"the percentage of spans that fail, for a level or service, as LEVEL:PERCENT or SERVICE:PERCENT; may be repeated (see README)" yaml:",omitempty"`
+	ErrorPropagate bool               `long:"errorpropagate" description:"if set, a span whose child fails also fails" yaml:",omitempty"`
+	FireAndForget  float64            `long:"fireandforget" description:"the percentage of spans that are fire-and-forget; their parent doesn't wait for them to finish" yaml:",omitempty"`
+	NewTraces      float64            `long:"newtraces" description:"the percentage of fire-and-forget spans that continue in a new trace, linked to the one that started them" yaml:",omitempty"`
+	BatchLinks     int                `long:"batchlinks" description:"if set, each trace is a batch consumer whose root links to this many recent traces" yaml:",omitempty"`
+	Events         int                `long:"events" description:"the number of events to add to each span" yaml:",omitempty"`
+	EventName      string             `long:"eventname" description:"the names of span events, using the same syntax as --operation" yaml:",omitempty"`
+	EventFields    map[string]string  `long:"eventfield" description:"a field to add to each span event, as NAME:VALUE using the same generators as fields; may be repeated" yaml:",omitempty"`
+	SpanKinds      bool               `long:"spankinds" description:"if set, spans get OTel span kinds, and calls between services become pairs of client and server spans" yaml:",omitempty"`
+	Timing         string             `long:"timing" description:"realtime sends spans as they happen; synthetic sends whole traces immediately, backdated to end now; deferred sends whole traces when they end" choice:"realtime" choice:"synthetic" choice:"deferred" default:"realtime"`
+}
+
 type Options struct {
 	Telemetry struct {
-		Host     string `long:"host" description:"the url of the host to receive the telemetry (or honeycomb, dogfood, local)" default:"honeycomb"`
-		Insecure bool   `long:"insecure" description:"use this for insecure http (not https) connections" yaml:",omitempty"`
-		Dataset  string `long:"dataset" description:"sends all traces to the given dataset" env:"HONEYCOMB_DATASET" default:"loadgen"`
-		APIKey   string `long:"apikey" description:"the honeycomb API key(*)" env:"HONEYCOMB_API_KEY" yaml:"-"`
+		Host        string            `long:"host" description:"the url of the host to receive the telemetry (or honeycomb, dogfood, local)" default:"honeycomb"`
+		Insecure    bool              `long:"insecure" description:"use this for insecure http (not https) connections" yaml:",omitempty"`
+		Dataset     string            `long:"dataset" description:"sends all traces to the given dataset" env:"HONEYCOMB_DATASET" default:"loadgen"`
+		APIKey      string            `long:"apikey" description:"the honeycomb API key(*)" env:"HONEYCOMB_API_KEY" yaml:"-"`
+		Headers     map[string]string `long:"header" description:"an extra header to send with every request (except by the honeycomb sender), as NAME:VALUE, where ${ENVVAR} in VALUE is replaced by the variable's value; may be repeated" yaml:",omitempty"`
+		BearerToken string            `long:"bearertoken" description:"send an Authorization header with this bearer token (except by the honeycomb sender); ${ENVVAR} is replaced by the variable's value" yaml:",omitempty"`
+		BasicAuth   string            `long:"basicauth" description:"send an Authorization header for basic auth with these credentials, as USER:PASSWORD (except by the honeycomb sender); ${ENVVAR} is replaced by the variable's value" yaml:",omitempty"`
+		CACert      string            `long:"cacert" description:"a PEM file of CA certificates to verify the host's certificate with, instead of the system's" yaml:",omitempty"`
+		ClientCert  string            `long:"clientcert" description:"a PEM file with a client certificate for mutual TLS; needs --clientkey" yaml:",omitempty"`
+		ClientKey   string            `long:"clientkey" description:"a PEM file with the private key for --clientcert" yaml:",omitempty"`
+		ServerName  string            `long:"servername" description:"the name to verify the host's certificate against, instead of the host's name" yaml:",omitempty"`
+		SkipVerify  bool              `long:"skipverify" description:"don't verify the host's certificate at all (for testing only)" yaml:",omitempty"`
 	} `group:"Telemetry Options"`
-	Format struct {
-		Depth     int           `long:"depth" description:"the nesting depth of each trace" default:"3"`
-		NSpans    int           `long:"nspans" description:"the total number of spans in a trace" default:"3"`
-		Extra     int           `long:"extra" description:"the number of random fields in a span beyond the standard ones" default:"0" yaml:",omitempty"`
-		TraceTime time.Duration `long:"tracetime" description:"the duration of a trace" default:"1s"`
-	} `group:"Trace Format Options"`
+	Format   FormatOptions `group:"Trace Format Options"`
 	Quantity struct {
-		TPS        int           `long:"tps" description:"the maximum number of traces to generate per second" default:"1"`
-		TraceCount int64         `long:"tracecount" description:"the maximum number of traces to generate (0 means no limit, but if runtime is not specified defaults to 1)" default:"0" yaml:",omitempty"`
-		RunTime    time.Duration `long:"runtime" description:"the maximum time to spend generating traces at max TPS (0 means no limit)" default:"0s" yaml:",omitempty"`
-		RampTime   time.Duration `long:"ramptime" description:"duration to spend ramping up or down to the desired TPS" default:"1s"`
+		TPS         int           `long:"tps" description:"the maximum number of traces to generate per second" default:"1"`
+		TraceCount  int64         `long:"tracecount" description:"the maximum number of traces to generate (0 means no limit, but if runtime is not specified defaults to 1)" default:"0" yaml:",omitempty"`
+		RunTime     time.Duration `long:"runtime" description:"the maximum time to spend generating traces at max TPS (0 means no limit)" default:"0s" yaml:",omitempty"`
+		RampTime    time.Duration `long:"ramptime" description:"duration to spend ramping up or down to the desired TPS" default:"1s"`
+		Profile     string        `long:"profile" description:"a rate profile describing how TPS changes over time; replaces tps, ramptime and runtime (see README)" yaml:",omitempty"`
+		Arrivals    string        `long:"arrivals" description:"how trace starts are spread out in time" choice:"constant" choice:"poisson" choice:"pareto" choice:"herd" default:"constant"`
+		ParetoShape float64       `long:"paretoshape" description:"for pareto arrivals, the shape of the distribution of gaps; closer to 1 is burstier" default:"1.5" yaml:",omitempty"`
+		HerdSize    int           `long:"herdsize" description:"for herd arrivals, the number of traces that start at the same instant" default:"10" yaml:",omitempty"`
+		Backfill    time.Duration `long:"backfill" description:"generate traces with timestamps spread over this much time before now, sending them as fast as possible (0 means run in real time)" default:"0s" yaml:",omitempty"`
+		MaxInFlight int           `long:"maxinflight" description:"the maximum number of traces in progress at once; trace starts beyond this are skipped (0 means no limit)" default:"10000" yaml:",omitempty"`
 	} `group:"Quantity Options"`
+	Replay struct {
+		File   string  `long:"replay" description:"replay the traces in this file instead of generating them; OTLP/JSON, OTLP protobuf or Honeycomb events (see README)" yaml:",omitempty"`
+		Format string  `long:"replayformat" description:"the format of the replay file; auto treats it as JSON if it starts with { or [" choice:"auto" choice:"json" choice:"proto" default:"auto"`
+		Speed  float64 `long:"replayspeed" description:"how many times faster than they were recorded to start replayed traces" default:"1"`
+		TPS    int     `long:"replaytps" description:"replay traces at this rate, like --tps, instead of the recorded one (0 means the recorded rate)" yaml:",omitempty"`
+	} `group:"Replay Options"`
 	Output struct {
-		Sender             string        `long:"sender" description:"type of sender" choice:"honeycomb" choice:"otel" choice:"print" choice:"dummy" default:"honeycomb"`
-		Protocol           string        `long:"protocol" description:"for otel only, protocol to use" choice:"grpc" choice:"http" default:"grpc"`
-		MaxQueueSize       int           `long:"maxqueuesize" description:"for otel only, maximum number of spans to queue before dropping"`
-		MaxExportBatchSize int           `long:"maxexportbatchsize" description:"for otel only, maximum number of spans to export at once"`
-		BatchTimeout       time.Duration `long:"batchtimeout" description:"for otel only, maximum time to wait before sending a batch"`
-		ExportTimeout      time.Duration `long:"exporttimeout" description:"for otel only, maximum time to wait for a batch send to be completed"`
+		Sender             string             `long:"sender" description:"type of sender" choice:"honeycomb" choice:"otel" choice:"otellogs" choice:"eventsapi" choice:"zipkin" choice:"jaeger" choice:"file" choice:"print" choice:"dummy" default:"honeycomb"`
+		Protocol           string             `long:"protocol" description:"for otel and otellogs, and for metrics, protocol to use" choice:"grpc" choice:"http" default:"grpc"`
+		OTLPPath           string             `long:"otlppath" description:"for otel and otellogs with the http protocol, the URL path to post spans or log records to (default: the host's path followed by /v1/traces or /v1/logs)" yaml:",omitempty"`
+		Compression        string             `long:"compression" description:"how requests are compressed; not every sender supports every codec (default: gzip for OTLP, zstd for honeycomb, none for eventsapi, zipkin and jaeger)" choice:"none" choice:"gzip" choice:"zstd" choice:"snappy" yaml:",omitempty"`
+		Resources          string             `long:"resources" description:"for otel and otellogs, whether all spans share one resource named for the dataset, or each service gets its own" choice:"dataset" choice:"service" default:"dataset"`
+		ResourceAttrs      map[string]string  `long:"resource" description:"for otel, otellogs, jaeger and file, a resource attribute as NAME:SPEC, generated once per resource; may be repeated (see README)" yaml:"resourceattrs,omitempty"`
+		EventsAs           string             `long:"eventsas" description:"for otel only, whether span events are sent as span events, as OTLP log records correlated with their spans, or both" choice:"span" choice:"log" choice:"both" default:"span"`
+		LogSeverity        map[string]float64 `long:"logseverity" description:"for otellogs only, the relative weight of a severity as SEVERITY:WEIGHT (trace, debug, info, warn, error or fatal); may be repeated (default info:1)" yaml:",omitempty"`
+		LogBody            string             `long:"logbody" description:"for otellogs only, the body of each log record, using the same syntax as --operation" yaml:",omitempty"`
+		EventsFormat       string             `long:"eventsformat" description:"for eventsapi only, how batches are encoded" choice:"json" choice:"msgpack" default:"json"`
+		ZipkinPath         string             `long:"zipkinpath" description:"for zipkin only, the path of the collector's span endpoint" default:"/api/v2/spans"`
+		JaegerPath         string             `long:"jaegerpath" description:"for jaeger only, the path of the collector's Thrift over HTTP endpoint" default:"/api/traces"`
+		File               string             `long:"file" description:"for file only, the file to write spans to, or - for stdout" default:"-"`
+		FileFormat         string             `long:"fileformat" description:"for file only, whether to write OTLP/JSON (one request per line) or OTLP protobuf (each request preceded by its length)" choice:"json" choice:"proto" default:"json"`
+		FileGzip           bool               `long:"filegzip" description:"for file only, gzip the output" yaml:",omitempty"`
+		FileMaxSize        int64              `long:"filemaxsize" description:"for file only, rotate the file when it reaches this many megabytes, before compression (0 means never)" yaml:",omitempty"`
+		Concurrency        int                `long:"concurrency" description:"for eventsapi, zipkin and jaeger, the number of batches to send at once" default:"10"`
+		Retries            int                `long:"retries" description:"for eventsapi, zipkin and jaeger, the number of times to retry a batch that fails with a network error, a 429 or a 5xx" default:"3"`
+		REDMetrics         bool               `long:"redmetrics" description:"also send OTLP metrics for the rate, errors and duration of spans, by service and operation" yaml:",omitempty"`
+		MetricSpecs        map[string]string  `long:"metric" description:"also send an OTLP metric as NAME:KIND:SPEC, where KIND is counter, histogram or gauge; may be repeated (see README)" yaml:"metrics,omitempty"`
+		MetricInterval     time.Duration      `long:"metricinterval" description:"how often to send OTLP metrics" default:"10s"`
+		MaxQueueSize       int                `long:"maxqueuesize" description:"for otel, otellogs, eventsapi, zipkin and jaeger, maximum number of spans, records or events to queue before dropping"`
+		MaxExportBatchSize int                `long:"maxexportbatchsize" description:"for otel, otellogs, eventsapi, zipkin, jaeger and file, maximum number of spans, records or events to export at once"`
+		BatchTimeout       time.Duration      `long:"batchtimeout" description:"for otel, otellogs, eventsapi, zipkin, jaeger and file, maximum time to wait before sending a batch"`
+		ExportTimeout      time.Duration      `long:"exporttimeout" description:"for otel, otellogs, eventsapi, zipkin and jaeger, maximum time to wait for a batch send to be completed"`
 	} `group:"Output Options"`
 	Global struct {
 		LogLevel  string `long:"loglevel" description:"level of logging" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"warn"`
@@ -55,8 +111,13 @@ type Options struct {
 		Config    string `long:"config" description:"name of config file to load(*)" default:"" yaml:"-"`
 		WriteCfg  string `long:"writecfg" description:"write effective YAML config to the specified output file and quit(*)" default:"" yaml:"-"`
 	} `group:"Global Options"`
-	Fields  map[string]string `yaml:"fields,omitempty"`
-	apihost *url.URL
+	Fields       map[string]string `yaml:"fields,omitempty"`
+	Topology     *Topology         `yaml:"topology,omitempty"`
+	Scenarios    []*Scenario       `yaml:"scenarios,omitempty"`
+	Destinations []*Destination    `yaml:"destinations,omitempty"`
+	apihost      *url.URL
+	tls          *tls.Config
+	replay       *Replay
 }
 
 func newOptions() *Options {
@@ -70,6 +131,11 @@ func (o *Options) CopyStarredFieldsFrom(other *Options) {
 	o.Global.WriteCfg = other.Global.WriteCfg
 }
 
+// metrics returns whether OTLP metrics are sent alongside the spans.
+func (o *Options) metrics() bool {
+	return o.Output.REDMetrics || len(o.Output.MetricSpecs) > 0
+}
+
 func (o *Options) DebugLevel() int {
 	switch o.Global.LogLevel {
 	case "debug":
@@ -155,7 +221,8 @@ func main() {
 	number of fields in spans. It supports setting the number of traces per second
 	it generates, and can generate a specific quanity of traces, or run for a
 	specific amount of time, or both. It can also control the speed at which it
-	ramps up and down to the target rate.
+	ramps up and down to the target rate, or follow a rate profile made of steps,
+	ramps, sine waves, square waves and spikes.
 
 	It can generate OTLP or Honeycomb-formatted traces, and send them to Honeycomb
 	or (for OTLP) to any OTel agent.
@@ -175,15 +242,97 @@ func main() {
 		- /b33.3 -- boolean, true or false -- probability of true is 33.3% (default 50%)
 		- /u -- https url-like, no query string, two path segments; default cardinality is 10/10 but can be changed like /u3,20
 		- /uq -- as /u above, but with query string containing a random key word with a completely random value
+		- /up -- as /u above, but only the path, like a route
 		- /st -- an http status code by default reflecting 95% 200s, 4% 400s, 1% 500s. 400s and 500s can be changed like /st10,0.1.
 		- /k50,60 -- an intermittent key field with total cardinality 50, but decreasing key frequency. All keys only arrive after 60 seconds
 
+	Span names are normally the same as service names, but can be set per level or
+	per service with --operation. Example: --operation="1:GET {/up5,10}|POST {/up5,10}"
+	names spans at level 1 like HTTP routes. The /up generator is like /u, but
+	produces only the path.
+
+	Span durations can follow a distribution per operation, service or level with
+	--latency. Example: --latency=checkout:lognormal:40ms,900ms gives checkout spans
+	a median of 40ms and a 99th percentile of 900ms; parents grow to cover their
+	children.
+
+	Spans fail at random with --errorrate, per level or service, with the same keys
+	as --operation. Example: --errorrate=*:1 --errorrate=payment:5 --errorpropagate
+	makes 1% of spans fail, 5% in the payment service, and marks their ancestors as
+	failing too.
+
+	With --spankinds, each call between services becomes a CLIENT span in the caller
+	and a SERVER span in the callee (PRODUCER and CONSUMER for async calls in a
+	topology).
+
+	Spans can carry events with --events, named with --eventname and with fields from
+	--eventfield. With --sender=otel, --eventsas=log sends them as OTLP log records
+	carrying the trace and span IDs of their spans instead (--eventsas=both does both).
+
+	Traces don't have to be strictly nested: --fireandforget makes some spans outlive
+	their parents, --newtraces continues some of those in new traces linked to the
+	original, and --batchlinks makes each trace's root link to other recent traces.
+
+	With --sender=otel and --resources=service, each service gets its own resource.
+	Resource attributes can be added with --resource, using the same syntax as span
+	names. Example: --resource=host.name:/sx8
+
+	--sender=eventsapi sends the same events as --sender=honeycomb directly to the
+	Honeycomb Events API, without the beeline, with its own batching and retries.
+
+	--sender=zipkin sends spans as Zipkin v2 JSON to --zipkinpath on the host.
+
+	--sender=jaeger sends spans as Jaeger Thrift batches, one per service, to a Jaeger
+	collector's --jaegerpath on the host. Example: --sender=jaeger --host=http://localhost:14268
+
+	--sender=file writes spans to --file (stdout by default) as OTLP/JSON, one export
+	request per line, or as OTLP protobuf with --fileformat=proto.
+
+	Extra headers can be sent to OTLP endpoints other than Honeycomb's with --header,
+	--bearertoken and --basicauth, where ${ENVVAR} is replaced by the variable's value.
+	Example: --sender=otel --protocol=http --host=https://otlp.example.com/otlp --bearertoken='${TOKEN}'
+
+	Every sender can use a private CA bundle (--cacert), a client certificate for
+	mutual TLS (--clientcert and --clientkey), a different name to verify the host's
+	certificate against (--servername), or no verification at all (--skipverify).
+
+	--compression sets how requests are compressed (none, gzip, zstd, or snappy for
+	OTLP), where the sender supports it; each sender reports the bytes it sent before
+	and after compression.
+
+	--sender=otellogs sends each span as an OTLP log record instead, with the span's
+	fields as attributes, to load a log pipeline. --logbody sets the body, using the
+	same syntax as span names, and --logseverity sets the mix of severities.
+	Example: --sender=otellogs --logseverity=info:90 --logseverity=warn:10
+
+	Any sender can also send OTLP metrics: --redmetrics records the rate, errors and
+	duration of spans by service and operation, and --metric adds metrics whose values
+	come from generators. Example: --sender=dummy --metric=queue.depth:gauge:/ig50,10
+	sends only metrics.
+
+	Instead of generating traces, loadgen can replay recorded ones with --replay=FILE,
+	reading OTLP/JSON or OTLP protobuf (as written by --sender=file) or Honeycomb
+	events. Each replayed trace gets new IDs and is shifted to start now. Traces start
+	at their recorded rate, sped up by --replayspeed, unless --replaytps or --profile
+	sets the rate instead. Example: --replay=capture.json --replayspeed=10 --sender=otel
+
 	Field names can be alphanumeric with underscores. If a field name is prefixed with
 	a number and a dot (e.g. 1.foo=bar) the field will only be injected into spans at
 	that level of nesting (where 0 is the root span).
 
 	Fields can also be specified in the config file as key/value pairs under the "fields" key.
 
+	A service topology -- services, their operations, and the calls between them --
+	can be specified in the config file under the "topology" key. When it is, traces
+	are generated by walking the topology instead of using depth and nspans.
+
+	A mix of different kinds of traces can be generated by listing "scenarios" in the
+	config file, each with its own format, fields, dataset and weight.
+
+	Identical traces can be sent to several places at once by listing "destinations"
+	in the config file, each with its own sender, host, protocol, API key, dataset
+	and headers.
+
 	Options can be set in a config file, or on the command line; to specify them in the
 	config file, specify it on the command line with "--config=FILENAME". The config file
 	format is YAML; see "example.yml" for an example.
@@ -243,51 +392,115 @@ func main() {
 		}()
 	}
 
-	// if we're not given a trace count or a runtime, send only 1 trace
-	if opts.Quantity.TraceCount == 0 && opts.Quantity.RunTime == 0 {
+	// if we're not given a trace count, a runtime, a profile, or a backfill, send
+	// only 1 trace (or, when replaying, each recorded trace once)
+	defaultCount := opts.Quantity.TraceCount == 0 && opts.Quantity.RunTime == 0 && opts.Quantity.Profile == "" && opts.Quantity.Backfill == 0
+	if defaultCount && opts.Replay.File == "" {
 		opts.Quantity.TraceCount = 1
 	}
 
 	log := NewLogger(opts.DebugLevel())
+	if opts.Output.Sender == "file" && opts.Output.File == "-" {
+		// keep stdout for the spans
+		log = NewStderrLogger(opts.DebugLevel())
+	}
 
-	getFielderFn := func() *Fielder {
-		getFielder, err := NewFielder(opts.Global.Seed, opts.Fields, opts.Format.Extra, opts.Format.Depth)
-		if err != nil {
-			log.Fatal("unable to create fields as specified: %s\n", err)
+	if opts.Topology != nil {
+		if err := opts.Topology.Validate(); err != nil {
+			log.Fatal("invalid topology: %s\n", err)
+		}
+	}
+	if err := ValidateScenarios(opts); err != nil {
+		log.Fatal("invalid scenarios: %s\n", err)
+	}
+	if err := ValidateDestinations(opts); err != nil {
+		log.Fatal("invalid destinations: %s\n", err)
+	}
+	if len(opts.Destinations) == 0 {
+		if err := ValidateCompression(opts.Output.Sender, opts.Output.Compression, opts.metrics()); err != nil {
+			log.Fatal("invalid compression: %s\n", err)
 		}
-		return getFielder
+	}
+	if err := ResolveHeaders(opts); err != nil {
+		log.Fatal("invalid headers: %s\n", err)
 	}
 
 	opts.apihost = parseHost(log, opts.Telemetry.Host, opts.Telemetry.Insecure)
+	if opts.tls, err = newTLSConfig(opts); err != nil {
+		log.Fatal("invalid TLS options: %s\n", err)
+	}
+	if opts.tls != nil && opts.Telemetry.Insecure {
+		log.Warn("TLS options are ignored with --insecure\n")
+	}
+
+	if opts.Replay.File != "" {
+		if opts.Topology != nil || len(opts.Scenarios) > 0 {
+			log.Fatal("a replay can't be combined with a topology or scenarios\n")
+		}
+		opts.replay = NewReplay(log, opts)
+		if defaultCount {
+			opts.Quantity.TraceCount = int64(opts.replay.Len())
+		}
+	}
 
 	log.Info("host: %s, dataset: %s, apikey: ...%4.4s\n", opts.apihost.String(), opts.Telemetry.Dataset, opts.Telemetry.APIKey)
 
-	var sender Sender
-	switch opts.Output.Sender {
-	case "dummy":
-		sender = NewSenderDummy(log, opts)
-	case "print":
-		sender = NewSenderPrint(log, opts)
-	case "honeycomb":
-		sender = NewSenderHoneycomb(opts)
-	case "otel":
-		sender = NewSenderOTel(log, opts)
+	// without any scenarios, the top-level options describe the only one
+	scenarios := opts.Scenarios
+	if len(scenarios) == 0 {
+		scenarios = []*Scenario{{Name: opts.Telemetry.Dataset, Weight: 1}}
+	}
+
+	// each scenario has its own trace generator, but scenarios that send to the
+	// same dataset share a sender
+	senders := make(map[string]Sender)
+	mix := NewScenarioMix(log, opts)
+	for _, sc := range scenarios {
+		scopts := sc.Options(opts)
+		sender, ok := senders[scopts.Telemetry.Dataset]
+		if !ok {
+			sender = newSender(log, scopts)
+			senders[scopts.Telemetry.Dataset] = sender
+		}
+		getFielderFn := func() *Fielder {
+			getFielder, err := NewFielder(scopts.Global.Seed, scopts.Fields, scopts.Format.Operations, scopts.Format.Extra, scopts.Format.Depth)
+			if err != nil {
+				log.Fatal("unable to create fields as specified: %s\n", err)
+			}
+			if err := getFielder.SetEvents(scopts.Format.Events, scopts.Format.EventName, scopts.Format.EventFields); err != nil {
+				log.Fatal("unable to create events as specified: %s\n", err)
+			}
+			return getFielder
+		}
+		mix.Add(NewTraceGenerator(sc.Name, sender, getFielderFn, log, scopts), sc.Weight)
+	}
+
+	run(log, opts, mix)
+	for _, sender := range senders {
+		sender.Close()
 	}
+}
 
-	// create a stop channel so we can shut down gracefully
+// run runs the generator until its schedule is complete, the trace count is
+// reached, or we're interrupted, and waits for everything to finish.
+func run(log Logger, opts *Options, generator Generator) {
+	// create a stop channel so we can shut down gracefully; any of those things
+	// can stop the run, but only the first one closes it
 	stop := make(chan struct{})
+	shutdown := sync.OnceFunc(func() { close(stop) })
 	// and a waitgroup so we can wait for everything to finish
 	wg := &sync.WaitGroup{}
 
 	// catch ctrl-c and close the stop channel
 	sigch := make(chan os.Signal, 1)
 	signal.Notify(sigch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigch)
 	// we don't want a wait group for this one, or we'll never exit
 	go func() {
 		select {
 		case <-sigch:
 			log.Warn("\nshutting down from operating system signal\n")
-			close(stop)
+			shutdown()
 			return
 		case <-stop:
 			return
@@ -305,17 +518,52 @@ func main() {
 		if !TraceCounter(log, opts.Quantity.TraceCount, counterChan, stop) {
 			// give the senders a chance to finish sending
 			time.Sleep(1 * time.Second)
-			close(stop)
+			shutdown()
 		}
 		wg.Done()
 	}()
 
-	// start the load generator to create spans and send them on the source chan
-	var generator Generator = NewTraceGenerator(sender, getFielderFn, log, opts)
+	// start the load generator to create spans and send them on the source chan,
+	// and stop everything else when its schedule is complete
 	wg.Add(1)
-	go generator.Generate(opts, wg, stop, counterChan)
+	go func() {
+		generator.Generate(opts, wg, stop, counterChan)
+		shutdown()
+	}()
 
 	// wait for things to finish
 	wg.Wait()
-	sender.Close()
+}
+
+func newSender(log Logger, opts *Options) Sender {
+	if len(opts.Destinations) > 0 {
+		return NewSenderFanout(log, opts)
+	}
+	var sender Sender
+	switch opts.Output.Sender {
+	case "dummy":
+		sender = NewSenderDummy(log, opts)
+	case "print":
+		sender = NewSenderPrint(log, opts)
+	case "honeycomb":
+		sender = NewSenderHoneycomb(log, opts)
+	case "otel":
+		sender = NewSenderOTel(log, opts)
+	case "otellogs":
+		sender = NewSenderOTelLogs(log, opts)
+	case "eventsapi":
+		sender = NewSenderEvents(log, opts)
+	case "zipkin":
+		sender = NewSenderZipkin(log, opts)
+	case "jaeger":
+		sender = NewSenderJaeger(log, opts)
+	case "file":
+		sender = NewSenderFile(log, opts)
+	default:
+		log.Fatal("unknown sender: %s\n", opts.Output.Sender)
+	}
+	if opts.metrics() {
+		sender = NewSenderMetrics(log, opts, sender)
+	}
+	return sender
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// runTest runs a single scenario with the dummy sender, with options parsed
+// from args, and returns the sender and the scenario mix when the run ends.
+func runTest(t *testing.T, args ...string) (*SenderDummy, *ScenarioMix) {
+	t.Helper()
+	opts := newOptions()
+	if _, err := flags.ParseArgs(opts, append([]string{"--sender=dummy", "--ramptime=0s"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger(0)
+	sender := NewSenderDummy(log, opts).(*SenderDummy)
+	mix := NewScenarioMix(log, opts)
+	mix.Add(NewTraceGenerator("test", sender, func() *Fielder {
+		f, _ := NewFielder("test", nil, nil, 0, opts.Format.Depth)
+		return f
+	}, log, opts), 1)
+
+	done := make(chan struct{})
+	go func() {
+		run(log, opts, mix)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run didn't finish")
+	}
+	return sender, mix
+}
+
+func Test_run(t *testing.T) {
+	// the schedule ends while the trace counter is still waiting for the
+	// senders, so both of them try to stop the run
+	sender, _ := runTest(t, "--tps=10", "--tracecount=5", "--runtime=600ms", "--tracetime=10ms")
+	if sender.tracecount.Load() != 5 {
+		t.Errorf("expected 5 traces, got %d", sender.tracecount.Load())
+	}
+}
+
+func Test_runDeferredInFlight(t *testing.T) {
+	// deferred traces hold their in-flight slot until they're sent, so only
+	// two can be waiting at once
+	sender, mix := runTest(t, "--tps=100", "--runtime=300ms", "--tracetime=100ms", "--timing=deferred", "--maxinflight=2")
+	s := mix.scheduler
+	if s.launched.Load() > 8 || s.skipped.Load() == 0 || sender.tracecount.Load() != s.launched.Load() {
+		t.Errorf("expected at most 8 traces with the rest skipped, started %d, skipped %d and sent %d",
+			s.launched.Load(), s.skipped.Load(), sender.tracecount.Load())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// countingLogExporter counts the log records that its exporter exports, and
+// the ones that it fails to, like countingExporter does for spans.
+type countingLogExporter struct {
+	sdklog.Exporter
+	exported atomic.Int64
+	failed   atomic.Int64
+}
+
+func (e *countingLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	err := e.Exporter.Export(ctx, records)
+	if err != nil {
+		e.failed.Add(int64(len(records)))
+	} else {
+		e.exported.Add(int64(len(records)))
+	}
+	return err
+}
+
+// newOTelLogProcessor creates an OTLP log exporter for the configured protocol
+// and a batch processor for it, using the same batching options as spans. The
+// exporter's requests are counted in bytes, and its records are counted too.
+func newOTelLogProcessor(log Logger, opts *Options, bytes *byteCounter) (sdklog.Processor, *countingLogExporter) {
+	var otlpExporter sdklog.Exporter
+	var err error
+	switch opts.Output.Protocol {
+	case "grpc":
+		otlpExporter, err = setupOTelLogGRPCExporter(opts, bytes)
+	case "http":
+		otlpExporter, err = setupOTelLogHTTPExporter(opts, bytes)
+	default:
+		log.Fatal("unknown protocol: %s", opts.Output.Protocol)
+	}
+	if err != nil {
+		log.Fatal("failure configuring otel log exporter: %v", err)
+	}
+	exporter := &countingLogExporter{Exporter: otlpExporter}
+
+	var bpOpts []sdklog.BatchProcessorOption
+	if opts.Output.BatchTimeout != 0 {
+		bpOpts = append(bpOpts, sdklog.WithExportInterval(opts.Output.BatchTimeout))
+	}
+	if opts.Output.MaxQueueSize != 0 {
+		bpOpts = append(bpOpts, sdklog.WithMaxQueueSize(opts.Output.MaxQueueSize))
+	}
+	if opts.Output.MaxExportBatchSize != 0 {
+		bpOpts = append(bpOpts, sdklog.WithExportMaxBatchSize(opts.Output.MaxExportBatchSize))
+	}
+	if opts.Output.ExportTimeout != 0 {
+		bpOpts = append(bpOpts, sdklog.WithExportTimeout(opts.Output.ExportTimeout))
+	}
+	return sdklog.NewBatchProcessor(exporter, bpOpts...), exporter
+}
+
+func setupOTelLogHTTPExporter(opts *Options, bytes *byteCounter) (sdklog.Exporter, error) {
+	options := []otlploghttp.Option{
+		otlploghttp.WithEndpoint(opts.apihost.Host),
+		otlploghttp.WithURLPath(otlpPath(opts, "logs")),
+		otlploghttp.WithHeaders(otlpHeaders(opts)),
+		// the client compresses requests itself, so that it can count their bytes
+		otlploghttp.WithHTTPClient(otlpHTTPClient(opts, bytes)),
+	}
+	if opts.Telemetry.Insecure {
+		options = append(options, otlploghttp.WithInsecure())
+	}
+	return otlploghttp.New(context.Background(), options...)
+}
+
+func setupOTelLogGRPCExporter(opts *Options, bytes *byteCounter) (sdklog.Exporter, error) {
+	options := []otlploggrpc.Option{
+		otlploggrpc.WithEndpoint(opts.apihost.Host),
+		otlploggrpc.WithHeaders(otlpHeaders(opts)),
+		otlploggrpc.WithDialOption(grpcDialOptions(bytes)...),
+	}
+	if opts.Telemetry.Insecure {
+		options = append(options, otlploggrpc.WithInsecure())
+	} else {
+		options = append(options, otlploggrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig(opts))))
+	}
+	return otlploggrpc.New(context.Background(), options...)
+}
+
+// emitLogRecord sends a span event as a log record. The context carries the
+// span, so the record gets its trace and span IDs and is correlated with it.
+func emitLogRecord(ctx context.Context, logger otellog.Logger, span trace.Span, ev SpanEvent) {
+	var rec otellog.Record
+	rec.SetTimestamp(ev.Time)
+	rec.SetObservedTimestamp(time.Now())
+	rec.SetEventName(ev.Name)
+	rec.SetBody(otellog.StringValue(ev.Name))
+	rec.SetSeverity(otellog.SeverityInfo)
+	rec.SetSeverityText("INFO")
+	for k, v := range ev.Fields {
+		rec.AddAttributes(logAttributeOf(k, v))
+	}
+	logger.Emit(trace.ContextWithSpan(ctx, span), rec)
+}
+
+// logAttributeOf converts a generated field value to a log attribute.
+func logAttributeOf(key string, val any) otellog.KeyValue {
+	switch v := val.(type) {
+	case int64:
+		return otellog.Int64(key, v)
+	case uint64:
+		return otellog.Int64(key, int64(v))
+	case float64:
+		return otellog.Float64(key, v)
+	case string:
+		return otellog.String(key, v)
+	case bool:
+		return otellog.Bool(key, v)
+	default:
+		panic(fmt.Sprintf("unknown type %T for %s -- implementation error in fielder.go", v, key))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/credentials"
+)
+
+// newOTelMetricReader creates an OTLP metric exporter for the configured
+// protocol and a reader that exports to it every --metricinterval. The
+// exporter's requests are counted in bytes.
+func newOTelMetricReader(log Logger, opts *Options, bytes *byteCounter) sdkmetric.Reader {
+	var exporter sdkmetric.Exporter
+	var err error
+	switch opts.Output.Protocol {
+	case "grpc":
+		exporter, err = setupOTelMetricGRPCExporter(opts, bytes)
+	case "http":
+		exporter, err = setupOTelMetricHTTPExporter(opts, bytes)
+	default:
+		log.Fatal("unknown protocol: %s", opts.Output.Protocol)
+	}
+	if err != nil {
+		log.Fatal("failure configuring otel metric exporter: %v", err)
+	}
+
+	readerOpts := []sdkmetric.PeriodicReaderOption{
+		sdkmetric.WithInterval(opts.Output.MetricInterval),
+	}
+	if opts.Output.ExportTimeout != 0 {
+		readerOpts = append(readerOpts, sdkmetric.WithTimeout(opts.Output.ExportTimeout))
+	}
+	return sdkmetric.NewPeriodicReader(exporter, readerOpts...)
+}
+
+func setupOTelMetricHTTPExporter(opts *Options, bytes *byteCounter) (sdkmetric.Exporter, error) {
+	options := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(opts.apihost.Host),
+		otlpmetrichttp.WithURLPath(otlpPath(opts, "metrics")),
+		otlpmetrichttp.WithHeaders(otlpHeaders(opts)),
+		// the client compresses requests itself, so that it can count their bytes
+		otlpmetrichttp.WithHTTPClient(otlpHTTPClient(opts, bytes)),
+	}
+	if opts.Telemetry.Insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+	return otlpmetrichttp.New(context.Background(), options...)
+}
+
+func setupOTelMetricGRPCExporter(opts *Options, bytes *byteCounter) (sdkmetric.Exporter, error) {
+	options := []otlpmetricgrpc.Option{
+		otlpmetricgrpc.WithEndpoint(opts.apihost.Host),
+		otlpmetricgrpc.WithHeaders(otlpHeaders(opts)),
+		otlpmetricgrpc.WithDialOption(grpcDialOptions(bytes)...),
+	}
+	if opts.Telemetry.Insecure {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	} else {
+		options = append(options, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig(opts))))
+	}
+	return otlpmetricgrpc.New(context.Background(), options...)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// otlpSpan converts a planned span to an OTLP span, in the same way the OTel
+// SDK would send it: fields and attributes become attributes, span events
+// become events, and an error sets the status and adds an exception event.
+// Attributes are sorted by key, so that the same span always looks the same.
+func otlpSpan(span *SpanPlan, fields map[string]any, events []SpanEvent) *tracepb.Span {
+	s := &tracepb.Span{
+		TraceId:           span.TraceID[:],
+		SpanId:            span.SpanID[:],
+		Name:              span.Name,
+		Kind:              tracepb.Span_SpanKind(span.Kind), // the values are the same
+		StartTimeUnixNano: uint64(span.Start.UnixNano()),
+		EndTimeUnixNano:   uint64(span.End.UnixNano()),
+	}
+	if !span.IsRoot() {
+		s.ParentSpanId = span.ParentID[:]
+	}
+	attrs := make(map[string]any, len(fields)+len(span.Attributes))
+	for k, v := range fields {
+		attrs[k] = v
+	}
+	for k, v := range span.Attributes {
+		attrs[k] = v
+	}
+	s.Attributes = otlpAttributes(attrs)
+	for _, ev := range events {
+		s.Events = append(s.Events, &tracepb.Span_Event{
+			TimeUnixNano: uint64(ev.Time.UnixNano()),
+			Name:         ev.Name,
+			Attributes:   otlpAttributes(ev.Fields),
+		})
+	}
+	for _, link := range span.Links {
+		s.Links = append(s.Links, &tracepb.Span_Link{TraceId: link.TraceID[:], SpanId: link.SpanID[:]})
+	}
+	if e := span.Error; e != nil {
+		s.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: e.Message}
+		s.Events = append(s.Events, &tracepb.Span_Event{
+			TimeUnixNano: uint64(span.End.UnixNano()),
+			Name:         "exception",
+			Attributes: otlpAttributes(map[string]any{
+				string(semconv.ExceptionTypeKey):       e.Type,
+				string(semconv.ExceptionMessageKey):    e.Message,
+				string(semconv.ExceptionStacktraceKey): e.Stacktrace,
+				string(semconv.ExceptionEscapedKey):    true,
+			}),
+		})
+	}
+	return s
+}
+
+// otlpAttributes converts a map of generated values to OTLP attributes, sorted
+// by key.
+func otlpAttributes(attrs map[string]any) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue(attributeOf(k, attrs[k])))
+	}
+	return kvs
+}
+
+func otlpKeyValue(kv attribute.KeyValue) *commonpb.KeyValue {
+	var v commonpb.AnyValue
+	switch kv.Value.Type() {
+	case attribute.BOOL:
+		v.Value = &commonpb.AnyValue_BoolValue{BoolValue: kv.Value.AsBool()}
+	case attribute.INT64:
+		v.Value = &commonpb.AnyValue_IntValue{IntValue: kv.Value.AsInt64()}
+	case attribute.FLOAT64:
+		v.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: kv.Value.AsFloat64()}
+	default:
+		v.Value = &commonpb.AnyValue_StringValue{StringValue: kv.Value.Emit()}
+	}
+	return &commonpb.KeyValue{Key: string(kv.Key), Value: &v}
+}
+
+// otlpValues converts OTLP attributes back to a map of values with the types
+// generated fields have. Arrays, maps and bytes are kept as their OTLP/JSON.
+func otlpValues(kvs []*commonpb.KeyValue) map[string]any {
+	if len(kvs) == 0 {
+		return nil
+	}
+	values := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		switch v := kv.Value.GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			values[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			values[kv.Key] = v.BoolValue
+		case *commonpb.AnyValue_IntValue:
+			values[kv.Key] = v.IntValue
+		case *commonpb.AnyValue_DoubleValue:
+			values[kv.Key] = v.DoubleValue
+		case nil:
+		default:
+			values[kv.Key] = protojson.Format(kv.Value)
+		}
+	}
+	return values
+}
+
+// otlpResource returns a resource for a service, with the given extra
+// attributes.
+func otlpResource(service string, attrs []attribute.KeyValue) *resourcepb.Resource {
+	r := &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{otlpKeyValue(semconv.ServiceNameKey.String(service))},
+	}
+	for _, kv := range attrs {
+		r.Attributes = append(r.Attributes, otlpKeyValue(kv))
+	}
+	return r
+}
+
+// otlpScope is the instrumentation scope of every span.
+func otlpScope() *commonpb.InstrumentationScope {
+	return &commonpb.InstrumentationScope{Name: ResourceLibrary, Version: ResourceVersion}
+}
+
+// the fields of OTLP messages that hold IDs, which OTLP/JSON encodes as hex
+// rather than the base64 that protojson uses for bytes
+var otlpIDFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// marshalOTLPJSON encodes a request as OTLP/JSON. That's protojson, except that
+// trace and span IDs are hex strings and enums are numbers.
+func marshalOTLPJSON(req *coltracepb.ExportTraceServiceRequest) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return recodeIDs(data, func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return hex.EncodeToString(b), err
+	})
+}
+
+// unmarshalOTLPJSON decodes a request from OTLP/JSON.
+func unmarshalOTLPJSON(data []byte, req *coltracepb.ExportTraceServiceRequest) error {
+	data, err := recodeIDs(data, func(s string) (string, error) {
+		b, err := hex.DecodeString(s)
+		return base64.StdEncoding.EncodeToString(b), err
+	})
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, req)
+}
+
+// recodeIDs rewrites every ID field in a JSON document with the given function.
+// Numbers are kept as they were written, since timestamps and 64-bit integer
+// attributes written as JSON numbers would lose precision as float64s.
+func recodeIDs(data []byte, recode func(string) (string, error)) ([]byte, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var walk func(v any) error
+	walk = func(v any) error {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, child := range v {
+				if s, ok := child.(string); ok && otlpIDFields[k] {
+					id, err := recode(s)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q: %w", k, s, err)
+					}
+					v[k] = id
+				} else if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []any:
+			for _, child := range v {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
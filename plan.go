@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"pgregory.net/rand"
+)
+
+// A SpanPlan describes a single span of a trace. The generator computes the
+// whole tree of plans for a trace -- IDs, names and timestamps included --
+// before anything is sent, and senders use the plan to build their spans.
+// That lets the same trace be emitted in real time or all at once.
+type SpanPlan struct {
+	TraceID    trace.TraceID
+	SpanID     trace.SpanID
+	ParentID   trace.SpanID // invalid (all zeros) for the root span
+	Service    string       // empty if the span is simply named for its service
+	Name       string
+	Level      int            // 0 is the root span
+	Kind       trace.SpanKind // unspecified unless span kinds are in use
+	Start      time.Time
+	End        time.Time
+	Error      *SpanError     // nil unless the span fails
+	Attributes map[string]any // attributes decided by the plan, rather than generated fields
+	Links      []SpanLink
+	Events     []SpanEvent // events decided by the plan, rather than generated by the fielder
+	Detached   bool        // the parent doesn't wait for this span, which may end after it
+	NewTrace   bool        // the span continues in a new trace, linked to its parent
+	Children   []*SpanPlan
+}
+
+func newTraceID() trace.TraceID {
+	var id trace.TraceID
+	binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	return id
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	return id
+}
+
+// newRootPlan returns the plan for the root span of a new trace.
+func newRootPlan(name string, start time.Time) *SpanPlan {
+	return &SpanPlan{
+		TraceID: newTraceID(),
+		SpanID:  newSpanID(),
+		Name:    name,
+		Start:   start,
+		End:     start,
+	}
+}
+
+// addChild appends a new child span to this one and returns it.
+func (s *SpanPlan) addChild(name string, start time.Time) *SpanPlan {
+	child := &SpanPlan{
+		TraceID:  s.TraceID,
+		SpanID:   newSpanID(),
+		ParentID: s.SpanID,
+		Name:     name,
+		Level:    s.Level + 1,
+		Start:    start,
+		End:      start,
+	}
+	s.Children = append(s.Children, child)
+	return child
+}
+
+// ServiceName returns the name of the service the span belongs to.
+func (s *SpanPlan) ServiceName() string {
+	if s.Service != "" {
+		return s.Service
+	}
+	return s.Name
+}
+
+func (s *SpanPlan) IsRoot() bool {
+	return !s.ParentID.IsValid()
+}
+
+func (s *SpanPlan) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Shift moves this span and all its descendants by d.
+func (s *SpanPlan) Shift(d time.Duration) {
+	s.Start = s.Start.Add(d)
+	s.End = s.End.Add(d)
+	for i := range s.Events {
+		s.Events[i].Time = s.Events[i].Time.Add(d)
+	}
+	for _, child := range s.Children {
+		child.Shift(d)
+	}
+}
+
+// Count returns the number of spans in this span's subtree, including itself.
+func (s *SpanPlan) Count() int {
+	n := 1
+	for _, child := range s.Children {
+		n += child.Count()
+	}
+	return n
+}
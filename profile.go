@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RateProfile describes how the target number of traces per second changes
+// over the course of a run. It is a sequence of segments, each of which has a
+// shape and a duration; only the last segment may be open-ended.
+type RateProfile struct {
+	segments []profileSegment
+}
+
+type profileSegment struct {
+	spec     string
+	duration time.Duration // 0 means it runs forever
+	rate     func(t time.Duration) float64
+}
+
+// profileSeparators split a profile into segments; newlines are allowed so that
+// profiles can be written one segment per line in a YAML block.
+var profileSeparators = func(r rune) bool { return r == ';' || r == '\n' }
+
+// NewRateProfile parses a profile specification like
+//
+//	ramp:0,100,1m; step:5m,100,500,1000; sine:200,800,24h
+//
+// See README.md for the list of shapes and their parameters.
+func NewRateProfile(spec string) (*RateProfile, error) {
+	p := &RateProfile{}
+	for _, s := range strings.FieldsFunc(spec, profileSeparators) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if len(p.segments) > 0 && p.segments[len(p.segments)-1].duration == 0 {
+			return nil, fmt.Errorf("profile segment %s follows a segment with no duration", s)
+		}
+		seg, err := parseProfileSegment(s)
+		if err != nil {
+			return nil, err
+		}
+		p.segments = append(p.segments, seg)
+	}
+	if len(p.segments) == 0 {
+		return nil, fmt.Errorf("profile %q has no segments", spec)
+	}
+	return p, nil
+}
+
+// DefaultRateProfile builds the profile described by the --tps, --ramptime and
+// --runtime options: ramp up to TPS, hold it for the runtime (forever if it's
+// 0), then ramp back down.
+func DefaultRateProfile(opts *Options) *RateProfile {
+	tps := float64(opts.Quantity.TPS)
+	ramp := opts.Quantity.RampTime
+	p := &RateProfile{}
+	if ramp > 0 {
+		p.segments = append(p.segments, rampSegment("ramp", 0, tps, ramp))
+	}
+	p.segments = append(p.segments, constSegment("const", tps, opts.Quantity.RunTime))
+	if ramp > 0 && opts.Quantity.RunTime > 0 {
+		p.segments = append(p.segments, rampSegment("ramp", tps, 0, ramp))
+	}
+	return p
+}
+
+// Rate returns the target rate at the given time since the start of the
+// profile, and false once the profile has finished.
+func (p *RateProfile) Rate(elapsed time.Duration) (float64, bool) {
+	for _, seg := range p.segments {
+		if seg.duration == 0 || elapsed < seg.duration {
+			return max(seg.rate(elapsed), 0), true
+		}
+		elapsed -= seg.duration
+	}
+	return 0, false
+}
+
+// Duration returns the total length of the profile, or 0 if it never ends.
+func (p *RateProfile) Duration() time.Duration {
+	var total time.Duration
+	for _, seg := range p.segments {
+		if seg.duration == 0 {
+			return 0
+		}
+		total += seg.duration
+	}
+	return total
+}
+
+func (p *RateProfile) String() string {
+	specs := make([]string, len(p.segments))
+	for i, seg := range p.segments {
+		specs[i] = seg.spec
+	}
+	return strings.Join(specs, "; ")
+}
+
+func constSegment(spec string, tps float64, d time.Duration) profileSegment {
+	return profileSegment{spec: spec, duration: d, rate: func(time.Duration) float64 { return tps }}
+}
+
+func rampSegment(spec string, from, to float64, d time.Duration) profileSegment {
+	return profileSegment{spec: spec, duration: d, rate: func(t time.Duration) float64 {
+		return from + (to-from)*float64(t)/float64(d)
+	}}
+}
+
+// profileArgs holds the comma-separated parameters of a segment; numbers and
+// durations are parsed on demand so each shape can check its own arguments.
+type profileArgs struct {
+	spec string
+	args []string
+}
+
+// count checks the number of parameters; a max of -1 means there is no upper limit.
+func (a profileArgs) count(min, max int) error {
+	if max < 0 && len(a.args) < min {
+		return fmt.Errorf("profile segment %s needs at least %d parameters", a.spec, min)
+	}
+	if len(a.args) < min || (max >= 0 && len(a.args) > max) {
+		if min == max {
+			return fmt.Errorf("profile segment %s needs %d parameters", a.spec, min)
+		}
+		return fmt.Errorf("profile segment %s needs %d to %d parameters", a.spec, min, max)
+	}
+	return nil
+}
+
+func (a profileArgs) tps(i int) (float64, error) {
+	f, err := strconv.ParseFloat(a.args[i], 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate %s in profile segment %s", a.args[i], a.spec)
+	}
+	return f, nil
+}
+
+func (a profileArgs) duration(i int) (time.Duration, error) {
+	if i >= len(a.args) {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(a.args[i])
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %s in profile segment %s", a.args[i], a.spec)
+	}
+	return d, nil
+}
+
+// parseProfileSegment parses a single segment of the form shape:p1,p2,...
+func parseProfileSegment(spec string) (profileSegment, error) {
+	shape, params, ok := strings.Cut(spec, ":")
+	if !ok {
+		return profileSegment{}, fmt.Errorf("profile segment %s must look like shape:parameters", spec)
+	}
+	a := profileArgs{spec: spec, args: strings.Split(params, ",")}
+	for i := range a.args {
+		a.args[i] = strings.TrimSpace(a.args[i])
+	}
+
+	// collect the errors from parsing each argument so we only check once
+	var firstErr error
+	tps := func(i int) float64 {
+		f, err := a.tps(i)
+		if firstErr == nil {
+			firstErr = err
+		}
+		return f
+	}
+	dur := func(i int) time.Duration {
+		d, err := a.duration(i)
+		if firstErr == nil {
+			firstErr = err
+		}
+		return d
+	}
+
+	var seg profileSegment
+	switch strings.TrimSpace(shape) {
+	case "const":
+		// const:tps[,duration]
+		if err := a.count(1, 2); err != nil {
+			return seg, err
+		}
+		seg = constSegment(spec, tps(0), dur(1))
+	case "ramp":
+		// ramp:from,to,duration
+		if err := a.count(3, 3); err != nil {
+			return seg, err
+		}
+		seg = rampSegment(spec, tps(0), tps(1), dur(2))
+	case "step":
+		// step:duration,tps1,tps2,... -- each step lasts for duration
+		if err := a.count(2, -1); err != nil {
+			return seg, err
+		}
+		each := dur(0)
+		levels := make([]float64, len(a.args)-1)
+		for i := range levels {
+			levels[i] = tps(i + 1)
+		}
+		seg = profileSegment{spec: spec, duration: each * time.Duration(len(levels)), rate: func(t time.Duration) float64 {
+			return levels[min(int(t/each), len(levels)-1)]
+		}}
+	case "sine":
+		// sine:min,max,period[,duration] -- starts at the midpoint, rising
+		if err := a.count(3, 4); err != nil {
+			return seg, err
+		}
+		lo, hi, period := tps(0), tps(1), dur(2)
+		seg = profileSegment{spec: spec, duration: dur(3), rate: func(t time.Duration) float64 {
+			phase := 2 * math.Pi * float64(t%period) / float64(period)
+			return lo + (hi-lo)*(1+math.Sin(phase))/2
+		}}
+	case "square":
+		// square:low,high,period[,duration] -- low for the first half of each period
+		if err := a.count(3, 4); err != nil {
+			return seg, err
+		}
+		lo, hi, period := tps(0), tps(1), dur(2)
+		seg = profileSegment{spec: spec, duration: dur(3), rate: func(t time.Duration) float64 {
+			if t%period < period/2 {
+				return lo
+			}
+			return hi
+		}}
+	case "spike":
+		// spike:base,peak,every,width[,duration] -- peak for width at the end of every interval
+		if err := a.count(4, 5); err != nil {
+			return seg, err
+		}
+		base, peak, every, width := tps(0), tps(1), dur(2), dur(3)
+		if firstErr == nil && width >= every {
+			return seg, fmt.Errorf("spike width must be shorter than its interval in profile segment %s", spec)
+		}
+		seg = profileSegment{spec: spec, duration: dur(4), rate: func(t time.Duration) float64 {
+			if t%every >= every-width {
+				return peak
+			}
+			return base
+		}}
+	default:
+		return seg, fmt.Errorf("unknown shape %s in profile segment %s", shape, spec)
+	}
+	if firstErr != nil {
+		return profileSegment{}, firstErr
+	}
+	return seg, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_NewRateProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		duration time.Duration
+		rates    map[time.Duration]float64
+	}{
+		{"const forever", "const:50", 0, map[time.Duration]float64{0: 50, time.Hour: 50}},
+		{"ramp", "ramp:0,100,10s", 10 * time.Second, map[time.Duration]float64{0: 0, 5 * time.Second: 50}},
+		{"steps", "step:5m,100,500,1000", 15 * time.Minute, map[time.Duration]float64{
+			0: 100, 4 * time.Minute: 100, 5 * time.Minute: 500, 14 * time.Minute: 1000,
+		}},
+		{"sine", "sine:200,800,24h,48h", 48 * time.Hour, map[time.Duration]float64{
+			0: 500, 6 * time.Hour: 800, 18 * time.Hour: 200, 30 * time.Hour: 800,
+		}},
+		{"square", "square:10,20,1m", 0, map[time.Duration]float64{0: 10, 31 * time.Second: 20, 61 * time.Second: 10}},
+		{"spike", "spike:10,1000,1m,5s", 0, map[time.Duration]float64{0: 10, 56 * time.Second: 1000, 61 * time.Second: 10}},
+		{"sequence", "ramp:0,100,1m; const:100,1m\nramp:100,0,1m", 3 * time.Minute, map[time.Duration]float64{
+			30 * time.Second: 50, 90 * time.Second: 100, 150 * time.Second: 50,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewRateProfile(tt.spec)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Duration() != tt.duration {
+				t.Errorf("duration = %v, want %v", p.Duration(), tt.duration)
+			}
+			for at, want := range tt.rates {
+				got, ok := p.Rate(at)
+				if !ok || math.Abs(got-want) > 0.001 {
+					t.Errorf("rate at %v = %v (%v), want %v", at, got, ok, want)
+				}
+			}
+			if tt.duration > 0 {
+				if _, ok := p.Rate(tt.duration); ok {
+					t.Errorf("expected profile to be finished at %v", tt.duration)
+				}
+			}
+		})
+	}
+}
+
+func Test_NewRateProfileErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"bogus:1,2",
+		"const",
+		"ramp:0,100",
+		"step:5m",
+		"sine:1,2,fast",
+		"const:-5,1m",
+		"spike:1,2,5s,10s",
+		"const:100; ramp:100,0,1m",
+	} {
+		if _, err := NewRateProfile(spec); err == nil {
+			t.Errorf("expected error for profile %q", spec)
+		}
+	}
+}
+
+func Test_DefaultRateProfile(t *testing.T) {
+	opts := newOptions()
+	opts.Quantity.TPS = 100
+	opts.Quantity.RampTime = 10 * time.Second
+	opts.Quantity.RunTime = time.Minute
+	p := DefaultRateProfile(opts)
+	if p.Duration() != 80*time.Second {
+		t.Errorf("duration = %v, want 80s", p.Duration())
+	}
+	for at, want := range map[time.Duration]float64{5 * time.Second: 50, 30 * time.Second: 100, 75 * time.Second: 50} {
+		if got, _ := p.Rate(at); math.Abs(got-want) > 0.001 {
+			t.Errorf("rate at %v = %v, want %v", at, got, want)
+		}
+	}
+}
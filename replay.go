@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"pgregory.net/rand"
+)
+
+// A Replay holds traces read from a file, and plans copies of them in turn,
+// in the order they were recorded, instead of generating new ones. Each copy
+// gets new IDs, and its timestamps are shifted so that it starts when the
+// scheduler starts it. The file can hold OTLP/JSON or OTLP protobuf, as written
+// by --sender=file, or Honeycomb events, as exported from Honeycomb or sent to
+// the Events API; it may be gzipped.
+type Replay struct {
+	traces []*SpanPlan // in the order they started
+	speed  float64
+	tps    int
+	salt   uint64
+	next   atomic.Int64
+}
+
+func NewReplay(log Logger, opts *Options) *Replay {
+	if opts.Replay.Speed <= 0 {
+		log.Fatal("replay speed must be greater than 0\n")
+	}
+	traces, err := readReplayFile(opts.Replay.File, opts.Replay.Format)
+	if err != nil {
+		log.Fatal("unable to read replay file: %v\n", err)
+	}
+	if len(traces) == 0 {
+		log.Fatal("replay file %s has no traces\n", opts.Replay.File)
+	}
+	log.Info("read %d traces to replay from %s\n", len(traces), opts.Replay.File)
+	return &Replay{
+		traces: traces,
+		speed:  opts.Replay.Speed,
+		tps:    opts.Replay.TPS,
+		salt:   rand.Uint64(),
+	}
+}
+
+// Len returns the number of traces in the replay file.
+func (r *Replay) Len() int {
+	return len(r.traces)
+}
+
+// PlanTrace plans a copy of the next recorded trace, starting at the given
+// time. When every trace has been replayed it starts again from the first.
+// Each pass through the file gets new IDs, but within a pass a recorded ID
+// always becomes the same new one, so links between traces still work.
+func (r *Replay) PlanTrace(start time.Time) *SpanPlan {
+	n := r.next.Add(1) - 1
+	recorded := r.traces[n%int64(len(r.traces))]
+	ids := replayIDs{salt: r.salt, pass: uint64(n / int64(len(r.traces)))}
+	return ids.copy(recorded, start.Sub(recorded.Start))
+}
+
+// RateProfile returns the profile for the scheduler to follow: traces start
+// at the rate they were recorded at, second by second, sped up by the replay
+// speed and repeated until the run time is up. With a replay TPS, it's the
+// usual profile for --tps instead, at that rate.
+func (r *Replay) RateProfile(opts *Options) *RateProfile {
+	if r.tps > 0 {
+		o := *opts
+		o.Quantity.TPS = r.tps
+		return DefaultRateProfile(&o)
+	}
+	first := r.traces[0].Start
+	var counts []float64
+	for _, t := range r.traces {
+		i := int(float64(t.Start.Sub(first)) / r.speed / float64(time.Second))
+		for len(counts) <= i {
+			counts = append(counts, 0)
+		}
+		counts[i]++
+	}
+	spec := fmt.Sprintf("replay:%d traces every %v", len(r.traces), time.Duration(len(counts))*time.Second)
+	return &RateProfile{segments: []profileSegment{{
+		spec:     spec,
+		duration: opts.Quantity.RunTime,
+		rate: func(t time.Duration) float64 {
+			return counts[int(t/time.Second)%len(counts)]
+		},
+	}}}
+}
+
+// replayIDs maps recorded IDs to new ones for one pass through the file, by
+// hashing them with a salt that's different for every run.
+type replayIDs struct {
+	salt uint64
+	pass uint64
+}
+
+func (ids replayIDs) sum(h hash.Hash, id []byte) []byte {
+	var prefix [16]byte
+	binary.BigEndian.PutUint64(prefix[:8], ids.salt)
+	binary.BigEndian.PutUint64(prefix[8:], ids.pass)
+	h.Write(prefix[:])
+	h.Write(id)
+	return h.Sum(nil)
+}
+
+func (ids replayIDs) traceID(id trace.TraceID) trace.TraceID {
+	var out trace.TraceID
+	copy(out[:], ids.sum(fnv.New128a(), id[:]))
+	return out
+}
+
+func (ids replayIDs) spanID(id trace.SpanID) trace.SpanID {
+	if !id.IsValid() {
+		return id
+	}
+	var out trace.SpanID
+	copy(out[:], ids.sum(fnv.New64a(), id[:]))
+	return out
+}
+
+// copy returns a copy of a recorded span and its descendants with new IDs,
+// shifted in time by d. The copy can be changed without affecting the
+// recording.
+func (ids replayIDs) copy(span *SpanPlan, d time.Duration) *SpanPlan {
+	c := *span
+	c.TraceID = ids.traceID(span.TraceID)
+	c.SpanID = ids.spanID(span.SpanID)
+	c.ParentID = ids.spanID(span.ParentID)
+	c.Start = span.Start.Add(d)
+	c.End = span.End.Add(d)
+	c.Attributes = maps.Clone(span.Attributes)
+	if span.Error != nil {
+		e := *span.Error
+		c.Error = &e
+	}
+	c.Links = make([]SpanLink, len(span.Links))
+	for i, link := range span.Links {
+		c.Links[i] = SpanLink{TraceID: ids.traceID(link.TraceID), SpanID: ids.spanID(link.SpanID)}
+	}
+	c.Events = make([]SpanEvent, len(span.Events))
+	for i, ev := range span.Events {
+		c.Events[i] = SpanEvent{Name: ev.Name, Time: ev.Time.Add(d), Fields: ev.Fields}
+	}
+	c.Children = make([]*SpanPlan, len(span.Children))
+	for i, child := range span.Children {
+		c.Children[i] = ids.copy(child, d)
+	}
+	return &c
+}
+
+// readReplayFile reads the traces in a file. With the auto format, a file that
+// starts with { or [ is JSON, and anything else is protobuf.
+func readReplayFile(path, format string) ([]*SpanPlan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = bufio.NewReader(zr)
+	}
+	if format == "auto" {
+		format = "proto"
+		if start, _ := r.Peek(512); len(start) > 0 {
+			if b := bytes.TrimLeft(start, " \t\r\n"); len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+				format = "json"
+			}
+		}
+	}
+
+	rt := newReplayTraces()
+	switch format {
+	case "json":
+		err = rt.readJSON(r)
+	case "proto":
+		err = rt.readProto(r)
+	default:
+		err = fmt.Errorf("unknown replay format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt.traces(), nil
+}
+
+type spanKey struct {
+	traceID trace.TraceID
+	spanID  trace.SpanID
+}
+
+// replayTraces collects recorded spans, along with the span events and links
+// that Honeycomb keeps as separate events, and assembles them into traces.
+type replayTraces struct {
+	spans  []*SpanPlan
+	events map[spanKey][]SpanEvent
+	links  map[spanKey][]SpanLink
+}
+
+func newReplayTraces() *replayTraces {
+	return &replayTraces{
+		events: make(map[spanKey][]SpanEvent),
+		links:  make(map[spanKey][]SpanLink),
+	}
+}
+
+// readProto reads OTLP export requests, each preceded by its length as a varint.
+func (rt *replayTraces) readProto(r *bufio.Reader) error {
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return err
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(msg, &req); err != nil {
+			return err
+		}
+		rt.addOTLP(&req)
+	}
+}
+
+// readJSON reads a sequence of JSON values, such as one per line. Each is an
+// OTLP/JSON export request, a Honeycomb event, or an array of either.
+func (rt *replayTraces) readJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := rt.addJSON(v); err != nil {
+			return err
+		}
+	}
+}
+
+func (rt *replayTraces) addJSON(v json.RawMessage) error {
+	if b := bytes.TrimLeft(v, " \t\r\n"); len(b) > 0 && b[0] == '[' {
+		var values []json.RawMessage
+		if err := json.Unmarshal(v, &values); err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := rt.addJSON(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var probe struct {
+		ResourceSpans json.RawMessage `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(v, &probe); err != nil {
+		return err
+	}
+	if probe.ResourceSpans != nil {
+		var req coltracepb.ExportTraceServiceRequest
+		if err := unmarshalOTLPJSON(v, &req); err != nil {
+			return err
+		}
+		rt.addOTLP(&req)
+		return nil
+	}
+
+	// numbers that are integers stay integers
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+	var ev map[string]any
+	if err := dec.Decode(&ev); err != nil {
+		return err
+	}
+	return rt.addHoneycomb(ev)
+}
+
+// addOTLP adds the spans in an export request. A span's service is the
+// service.name of its resource, unless the span has a service.name of its own,
+// as it does when loadgen writes it with --resources=dataset. loadgen's count
+// is dropped.
+func (rt *replayTraces) addOTLP(req *coltracepb.ExportTraceServiceRequest) {
+	for _, rs := range req.ResourceSpans {
+		var service string
+		for _, kv := range rs.GetResource().GetAttributes() {
+			if kv.Key == "service.name" {
+				service = kv.Value.GetStringValue()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				rt.spans = append(rt.spans, replayOTLPSpan(service, s))
+			}
+		}
+	}
+}
+
+func replayOTLPSpan(service string, s *tracepb.Span) *SpanPlan {
+	span := &SpanPlan{
+		Service:    service,
+		Name:       s.Name,
+		Kind:       trace.SpanKind(s.Kind), // the values are the same
+		Start:      time.Unix(0, int64(s.StartTimeUnixNano)),
+		End:        time.Unix(0, int64(s.EndTimeUnixNano)),
+		Attributes: otlpValues(s.Attributes),
+	}
+	copy(span.TraceID[:], s.TraceId)
+	copy(span.SpanID[:], s.SpanId)
+	copy(span.ParentID[:], s.ParentSpanId)
+	if svc, ok := span.Attributes["service.name"].(string); ok {
+		span.Service = svc
+		delete(span.Attributes, "service.name")
+	}
+	// the count is loadgen's own, and each replayed trace gets a new one
+	delete(span.Attributes, "count")
+	if s.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		span.Error = &SpanError{Message: s.Status.GetMessage()}
+	}
+	for _, ev := range s.Events {
+		fields := otlpValues(ev.Attributes)
+		if ev.Name == "exception" && span.Error != nil && span.Error.Type == "" {
+			// senders add the exception event for the span's error themselves
+			span.Error.Type, _ = fields["exception.type"].(string)
+			span.Error.Stacktrace, _ = fields["exception.stacktrace"].(string)
+			if msg, ok := fields["exception.message"].(string); ok && span.Error.Message == "" {
+				span.Error.Message = msg
+			}
+			continue
+		}
+		span.Events = append(span.Events, SpanEvent{Name: ev.Name, Time: time.Unix(0, int64(ev.TimeUnixNano)), Fields: fields})
+	}
+	for _, l := range s.Links {
+		var link SpanLink
+		copy(link.TraceID[:], l.TraceId)
+		copy(link.SpanID[:], l.SpanId)
+		span.Links = append(span.Links, link)
+	}
+	return span
+}
+
+// the keys of the timestamp in Honeycomb events that aren't wrapped with their
+// time, as Honeycomb exports them
+var honeycombTimeKeys = []string{"Timestamp", "timestamp", "time"}
+
+// span kinds by the names Honeycomb gives them
+var honeycombKinds = map[string]trace.SpanKind{
+	"internal": trace.SpanKindInternal,
+	"server":   trace.SpanKindServer,
+	"client":   trace.SpanKindClient,
+	"producer": trace.SpanKindProducer,
+	"consumer": trace.SpanKindConsumer,
+}
+
+// addHoneycomb adds a Honeycomb event, either as sent to the Events API, with
+// its fields under "data" and its timestamp under "time", or flat, with its
+// timestamp among its fields. Events without a trace ID aren't spans, and are
+// skipped. The beeline trace fields become the span's IDs, name, service,
+// kind and duration; span events and links, which are events of their own,
+// are attached to their spans. Honeycomb's meta fields, and loadgen's count,
+// are dropped.
+func (rt *replayTraces) addHoneycomb(ev map[string]any) error {
+	data, ok := ev["data"].(map[string]any)
+	var ts any
+	if ok {
+		ts = ev["time"]
+	} else {
+		data = ev
+		for _, key := range honeycombTimeKeys {
+			if v, ok := data[key]; ok {
+				ts = v
+				delete(data, key)
+				break
+			}
+		}
+	}
+	traceID, ok := data["trace.trace_id"].(string)
+	if !ok {
+		return nil
+	}
+	s, _ := ts.(string)
+	start, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("event in trace %s has no valid time: %v", traceID, ts)
+	}
+
+	str := func(key string) string {
+		s, _ := data[key].(string)
+		delete(data, key)
+		return s
+	}
+	tid := replayTraceID(traceID)
+	spanID := replaySpanID(str("trace.span_id"))
+	parentID := replaySpanID(str("trace.parent_id"))
+	name := str("name")
+	service := str("service.name")
+	if s := str("service_name"); service == "" {
+		service = s
+	}
+	kind := honeycombKinds[str("span.kind")]
+	annotation := str("meta.annotation_type")
+	linkTrace, linkSpan := str("trace.link.trace_id"), str("trace.link.span_id")
+	var duration time.Duration
+	if d, ok := data["duration_ms"].(json.Number); ok {
+		ms, _ := d.Float64()
+		duration = time.Duration(ms * float64(time.Millisecond))
+	}
+	delete(data, "duration_ms")
+	delete(data, "trace.trace_id")
+	delete(data, "count")
+
+	var spanErr *SpanError
+	if failed, _ := data["error"].(bool); failed {
+		spanErr = &SpanError{
+			Type:       str("exception.type"),
+			Message:    str("exception.message"),
+			Stacktrace: str("exception.stacktrace"),
+		}
+		if msg := str("status_message"); spanErr.Message == "" {
+			spanErr.Message = msg
+		}
+		spanErr.Propagated, _ = data["error.propagated"].(bool)
+		delete(data, "error")
+		delete(data, "error.propagated")
+	}
+
+	fields := make(map[string]any, len(data))
+	for k, v := range data {
+		if strings.HasPrefix(k, "meta.") || v == nil {
+			continue
+		}
+		fields[k] = honeycombValue(v)
+	}
+
+	key := spanKey{traceID: tid, spanID: parentID}
+	switch annotation {
+	case "span_event":
+		rt.events[key] = append(rt.events[key], SpanEvent{Name: name, Time: start, Fields: fields})
+	case "link":
+		rt.links[key] = append(rt.links[key], SpanLink{TraceID: replayTraceID(linkTrace), SpanID: replaySpanID(linkSpan)})
+	default:
+		span := &SpanPlan{
+			TraceID:  tid,
+			SpanID:   spanID,
+			ParentID: parentID,
+			Service:  service,
+			Name:     name,
+			Kind:     kind,
+			Start:    start,
+			End:      start.Add(duration),
+			Error:    spanErr,
+		}
+		if len(fields) > 0 {
+			span.Attributes = fields
+		}
+		rt.spans = append(rt.spans, span)
+	}
+	return nil
+}
+
+// honeycombValue converts a value decoded from JSON to one of the types that
+// generated fields have. Objects and arrays are kept as their JSON.
+func honeycombValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case string, bool:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// replayTraceID returns the trace ID for a recorded one. Hex IDs of the right
+// length are kept; any other string, such as a UUID, is hashed.
+func replayTraceID(s string) trace.TraceID {
+	var id trace.TraceID
+	if b, err := hex.DecodeString(s); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+		return id
+	}
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// replaySpanID returns the span ID for a recorded one, like replayTraceID. An
+// empty string is an invalid span ID, as roots have for their parent.
+func replaySpanID(s string) trace.SpanID {
+	var id trace.SpanID
+	if s == "" {
+		return id
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) == len(id) {
+		copy(id[:], b)
+		return id
+	}
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// traces assembles the spans into traces, in the order they started.
+func (rt *replayTraces) traces() []*SpanPlan {
+	byTrace := make(map[trace.TraceID][]*SpanPlan)
+	var order []trace.TraceID
+	for _, span := range rt.spans {
+		key := spanKey{traceID: span.TraceID, spanID: span.SpanID}
+		if events := rt.events[key]; len(events) > 0 {
+			span.Events = append(span.Events, events...)
+			sort.SliceStable(span.Events, func(i, j int) bool { return span.Events[i].Time.Before(span.Events[j].Time) })
+		}
+		span.Links = append(span.Links, rt.links[key]...)
+		if _, ok := byTrace[span.TraceID]; !ok {
+			order = append(order, span.TraceID)
+		}
+		byTrace[span.TraceID] = append(byTrace[span.TraceID], span)
+	}
+	traces := make([]*SpanPlan, 0, len(order))
+	for _, id := range order {
+		traces = append(traces, assembleTrace(byTrace[id]))
+	}
+	sort.SliceStable(traces, func(i, j int) bool { return traces[i].Start.Before(traces[j].Start) })
+	return traces
+}
+
+// assembleTrace builds the tree of spans for a trace, with the children of
+// each span in the order they start. The root is the earliest span without a
+// parent, or else the earliest span. Any other span whose parent isn't in the
+// trace is attached to the root as a detached child, since senders expect each
+// trace to have a single root.
+func assembleTrace(spans []*SpanPlan) *SpanPlan {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	byID := make(map[trace.SpanID]*SpanPlan, len(spans))
+	var root *SpanPlan
+	for _, span := range spans {
+		byID[span.SpanID] = span
+		if root == nil && span.IsRoot() {
+			root = span
+		}
+	}
+	if root == nil {
+		root = spans[0]
+		root.ParentID = trace.SpanID{}
+	}
+	for _, span := range spans {
+		if span == root {
+			continue
+		}
+		parent, ok := byID[span.ParentID]
+		if !ok || parent == span {
+			parent = root
+			span.ParentID = root.SpanID
+			span.Detached = true
+		}
+		parent.Children = append(parent.Children, span)
+	}
+	var setLevels func(span *SpanPlan, level int)
+	setLevels = func(span *SpanPlan, level int) {
+		span.Level = level
+		for _, child := range span.Children {
+			setLevels(child, level+1)
+		}
+	}
+	setLevels(root, 0)
+	return root
+}
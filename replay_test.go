@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_readReplayFile_Honeycomb(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.json")
+	data := `[{"Timestamp":"2024-05-01T10:00:00Z","trace.trace_id":"abc-123","trace.span_id":"s1","name":"GET /","service.name":"web","duration_ms":120.5,"http.status_code":200,"meta.signal_type":"trace"},
+{"Timestamp":"2024-05-01T10:00:00.01Z","trace.trace_id":"abc-123","trace.span_id":"s2","trace.parent_id":"s1","name":"SELECT","service.name":"db","duration_ms":50,"error":true,"exception.message":"timeout","span.kind":"client"},
+{"Timestamp":"2024-05-01T10:00:00.02Z","trace.trace_id":"abc-123","trace.parent_id":"s2","name":"retry","meta.annotation_type":"span_event","attempt":2}]
+{"time":"2024-05-01T10:00:02Z","data":{"trace.trace_id":"def-456","trace.span_id":"t1","name":"POST /","service_name":"web","duration_ms":30}}
+{"time":"2024-05-01T10:00:03Z","data":{"message":"not a span"}}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	traces, err := readReplayFile(path, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 traces, got %d", len(traces))
+	}
+
+	root := traces[0]
+	if root.Name != "GET /" || root.Service != "web" || root.Duration() != 120500*time.Microsecond {
+		t.Errorf("unexpected root %+v", root)
+	}
+	if root.Attributes["http.status_code"] != int64(200) || root.Attributes["meta.signal_type"] != nil {
+		t.Errorf("unexpected root attributes %v", root.Attributes)
+	}
+	if len(root.Children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(root.Children))
+	}
+	child := root.Children[0]
+	if child.ParentID != root.SpanID || child.Level != 1 || child.Kind != trace.SpanKindClient {
+		t.Errorf("unexpected child %+v", child)
+	}
+	if child.Error == nil || child.Error.Message != "timeout" || child.Attributes["error"] != nil {
+		t.Errorf("unexpected child error %+v, attributes %v", child.Error, child.Attributes)
+	}
+	if len(child.Events) != 1 || child.Events[0].Name != "retry" || child.Events[0].Fields["attempt"] != int64(2) {
+		t.Errorf("unexpected child events %+v", child.Events)
+	}
+	if traces[1].Service != "web" || !traces[1].IsRoot() {
+		t.Errorf("unexpected second trace %+v", traces[1])
+	}
+}
+
+func Test_readReplayFile_OTLP(t *testing.T) {
+	for _, format := range []string{"json", "proto"} {
+		path := filepath.Join(t.TempDir(), "spans."+format)
+		sender := newTestSenderFile(path, format)
+		sender.perService = true
+		recorded := writeTraces(t, sender, 2)
+
+		traces, err := readReplayFile(path, "auto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(traces) != 2 {
+			t.Fatalf("%s: expected 2 traces, got %d", format, len(traces))
+		}
+		for i, root := range traces {
+			want := recorded[i]
+			if root.TraceID != want.TraceID || root.SpanID != want.SpanID || !root.Start.Equal(want.Start) {
+				t.Errorf("%s: trace %d doesn't match the recording", format, i)
+			}
+			if len(root.Children) != 1 || root.Children[0].SpanID != want.Children[0].SpanID {
+				t.Errorf("%s: trace %d has the wrong children", format, i)
+			}
+			if _, ok := root.Attributes["count"]; ok {
+				t.Errorf("%s: trace %d kept its count", format, i)
+			}
+		}
+	}
+}
+
+func Test_Replay_PlanTrace(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	recorded := newRootPlan("root", start)
+	recorded.End = start.Add(time.Second)
+	child := recorded.addChild("child", start.Add(time.Millisecond))
+	child.End = recorded.End
+	child.Events = []SpanEvent{{Name: "retry", Time: start.Add(2 * time.Millisecond)}}
+	child.Links = []SpanLink{{TraceID: recorded.TraceID, SpanID: recorded.SpanID}}
+	r := &Replay{traces: []*SpanPlan{recorded}, salt: 1}
+
+	now := time.Now()
+	first := r.PlanTrace(now)
+	second := r.PlanTrace(now)
+	checkPlan(t, first, nil)
+	if first.TraceID == recorded.TraceID || first.TraceID == second.TraceID || first.SpanID == second.SpanID {
+		t.Errorf("IDs weren't rewritten")
+	}
+	copied := first.Children[0]
+	if !first.Start.Equal(now) || !copied.Events[0].Time.Equal(now.Add(2*time.Millisecond)) {
+		t.Errorf("times weren't shifted: %v, %v", first.Start, copied.Events[0].Time)
+	}
+	if copied.Links[0].TraceID != first.TraceID || copied.Links[0].SpanID != first.SpanID {
+		t.Errorf("link doesn't point to the replayed root")
+	}
+	if !recorded.Start.Equal(start) || !child.Events[0].Time.Equal(start.Add(2*time.Millisecond)) {
+		t.Errorf("the recording was changed")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ResourceGenerator generates resource attributes for services. Each service
+// gets its own values, generated the first time the service is seen and kept
+// for the rest of the run, so that (for example) a service always reports the
+// same host.name. It is safe for concurrent use.
+type ResourceGenerator struct {
+	mut   sync.Mutex
+	keys  []string
+	gens  map[string]func() string
+	cache map[string][]attribute.KeyValue
+}
+
+// NewResourceGenerator takes a map of attribute names to name specs, which use
+// the same syntax as span names (see parseNameTemplate).
+func NewResourceGenerator(seed string, specs map[string]string) (*ResourceGenerator, error) {
+	rng := NewRng(seed)
+	r := &ResourceGenerator{
+		gens:  make(map[string]func() string),
+		cache: make(map[string][]attribute.KeyValue),
+	}
+	for key := range specs {
+		r.keys = append(r.keys, key)
+	}
+	sort.Strings(r.keys)
+	for _, key := range r.keys {
+		gen, err := parseNameTemplate(rng, specs[key])
+		if err != nil {
+			return nil, err
+		}
+		r.gens[key] = gen
+	}
+	return r, nil
+}
+
+// Attributes returns the resource attributes for the given service.
+func (r *ResourceGenerator) Attributes(service string) []attribute.KeyValue {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if attrs, ok := r.cache[service]; ok {
+		return attrs
+	}
+	attrs := make([]attribute.KeyValue, 0, len(r.keys))
+	for _, key := range r.keys {
+		attrs = append(attrs, attribute.String(key, r.gens[key]()))
+	}
+	r.cache[service] = attrs
+	return attrs
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func Test_ResourceGenerator(t *testing.T) {
+	r, err := NewResourceGenerator("hello", map[string]string{"host.name": "/sx8", "deployment.environment": "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := r.Attributes("cart")
+	if len(a) != 2 || a[0].Key != "deployment.environment" || a[1].Key != "host.name" {
+		t.Fatalf("unexpected attributes %v", a)
+	}
+	if b := r.Attributes("cart"); b[1].Value.AsString() != a[1].Value.AsString() {
+		t.Errorf("host.name changed from %s to %s", a[1].Value.AsString(), b[1].Value.AsString())
+	}
+	if _, err := NewResourceGenerator("hello", map[string]string{"host.name": "{/zz}"}); err == nil {
+		t.Errorf("expected error for invalid generator")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"pgregory.net/rand"
+)
+
+// A Scenario is one kind of trace in a mix of traffic. Scenarios are specified
+// in the config file; any format option left unset in a scenario is taken from
+// the top-level format, and its fields are added to the top-level fields.
+// Scenarios are chosen at random for each trace in proportion to their weights,
+// or to their TPS if they give that instead.
+type Scenario struct {
+	Name     string            `yaml:"name"`
+	Weight   float64           `yaml:"weight,omitempty"`
+	TPS      int               `yaml:"tps,omitempty"`
+	Dataset  string            `yaml:"dataset,omitempty"`
+	Format   FormatOptions     `yaml:"format,omitempty"`
+	Fields   map[string]string `yaml:"fields,omitempty"`
+	Topology *Topology         `yaml:"topology,omitempty"`
+}
+
+// ValidateScenarios checks the scenarios in opts and fills in their names and
+// weights. If the scenarios give a TPS instead of a weight, the overall TPS is
+// set to the sum of them.
+func ValidateScenarios(opts *Options) error {
+	names := make(map[string]bool)
+	withTPS := 0
+	totalTPS := 0
+	for i, sc := range opts.Scenarios {
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("scenario%d", i+1)
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("duplicate scenario name %s", sc.Name)
+		}
+		names[sc.Name] = true
+		if sc.Weight < 0 || sc.TPS < 0 {
+			return fmt.Errorf("scenario %s: weight and tps must not be negative", sc.Name)
+		}
+		if sc.Weight > 0 && sc.TPS > 0 {
+			return fmt.Errorf("scenario %s: specify either weight or tps, not both", sc.Name)
+		}
+		if sc.TPS > 0 {
+			withTPS++
+			totalTPS += sc.TPS
+		}
+		if sc.Topology != nil {
+			if err := sc.Topology.Validate(); err != nil {
+				return fmt.Errorf("scenario %s: invalid topology: %w", sc.Name, err)
+			}
+		}
+	}
+	if withTPS > 0 && withTPS < len(opts.Scenarios) {
+		return fmt.Errorf("either all scenarios or none of them must specify tps")
+	}
+	// the beeline is global, so all the scenarios' honeycomb senders would
+	// share its settings and its counts
+	datasets := make(map[string]bool)
+	for _, sc := range opts.Scenarios {
+		datasets[sc.Options(opts).Telemetry.Dataset] = true
+	}
+	if len(datasets) > 1 && usesHoneycomb(opts) {
+		return fmt.Errorf("scenarios with different datasets can't use the honeycomb sender, because the beeline is global; use the eventsapi or otel sender")
+	}
+	for _, sc := range opts.Scenarios {
+		switch {
+		case sc.TPS > 0:
+			sc.Weight = float64(sc.TPS)
+		case sc.Weight == 0:
+			sc.Weight = 1
+		}
+	}
+	if withTPS > 0 {
+		opts.Quantity.TPS = totalTPS
+	}
+	return validateBatchLinks(opts)
+}
+
+// Options returns a copy of base with the scenario's settings applied.
+func (sc *Scenario) Options(base *Options) *Options {
+	opts := *base
+	if sc.Dataset != "" {
+		opts.Telemetry.Dataset = sc.Dataset
+	}
+	f := sc.Format
+	if f.Depth != 0 {
+		opts.Format.Depth = f.Depth
+	}
+	if f.NSpans != 0 {
+		opts.Format.NSpans = f.NSpans
+	}
+	if f.Extra != 0 {
+		opts.Format.Extra = f.Extra
+	}
+	if f.TraceTime != 0 {
+		opts.Format.TraceTime = f.TraceTime
+	}
+	if f.Operations != nil {
+		opts.Format.Operations = f.Operations
+	}
+	if f.Latencies != nil {
+		opts.Format.Latencies = f.Latencies
+	}
+	if f.ErrorRates != nil {
+		opts.Format.ErrorRates = f.ErrorRates
+	}
+	if f.ErrorPropagate {
+		opts.Format.ErrorPropagate = true
+	}
+	if f.FireAndForget != 0 {
+		opts.Format.FireAndForget = f.FireAndForget
+	}
+	if f.NewTraces != 0 {
+		opts.Format.NewTraces = f.NewTraces
+	}
+	if f.BatchLinks != 0 {
+		opts.Format.BatchLinks = f.BatchLinks
+	}
+	if f.Events != 0 {
+		opts.Format.Events = f.Events
+	}
+	if f.EventName != "" {
+		opts.Format.EventName = f.EventName
+	}
+	if f.EventFields != nil {
+		opts.Format.EventFields = f.EventFields
+	}
+	if f.SpanKinds {
+		opts.Format.SpanKinds = true
+	}
+	if f.Timing != "" {
+		opts.Format.Timing = f.Timing
+	}
+	opts.Fields = make(map[string]string)
+	for k, v := range base.Fields {
+		opts.Fields[k] = v
+	}
+	for k, v := range sc.Fields {
+		opts.Fields[k] = v
+	}
+	if sc.Topology != nil {
+		opts.Topology = sc.Topology
+	}
+	return &opts
+}
+
+// ScenarioMix is a Generator that runs a single scheduler and, for each trace
+// it starts, picks one of its scenarios at random according to their weights.
+type ScenarioMix struct {
+	scenarios   []*TraceGenerator
+	weights     []float64
+	totalWeight float64
+	scheduler   *Scheduler
+	log         Logger
+}
+
+// make sure it implements Generator
+var _ Generator = (*ScenarioMix)(nil)
+
+func NewScenarioMix(log Logger, opts *Options) *ScenarioMix {
+	return &ScenarioMix{
+		scheduler: NewScheduler(log, opts),
+		log:       log,
+	}
+}
+
+// Add adds a scenario to the mix with the given weight.
+func (m *ScenarioMix) Add(tg *TraceGenerator, weight float64) {
+	m.scenarios = append(m.scenarios, tg)
+	m.weights = append(m.weights, weight)
+	m.totalWeight += weight
+}
+
+// pick chooses a scenario at random according to the weights.
+func (m *ScenarioMix) pick() *TraceGenerator {
+	r := rand.Float64() * m.totalWeight
+	for i, w := range m.weights {
+		if r < w {
+			return m.scenarios[i]
+		}
+		r -= w
+	}
+	return m.scenarios[len(m.scenarios)-1]
+}
+
+func (m *ScenarioMix) Generate(opts *Options, wg *sync.WaitGroup, stop chan struct{}, counter chan int64) {
+	defer wg.Done()
+	m.log.Info("starting scheduler with max in flight: %d\n", opts.Quantity.MaxInFlight)
+	finished := m.scheduler.Run(stop, counter, func(count int64, start time.Time) {
+		m.pick().generate_trace(count, start)
+	})
+	// detached spans aren't tracked by the scheduler, so wait for them here
+	for _, tg := range m.scenarios {
+		tg.pending.Wait()
+	}
+	if len(m.scenarios) > 1 {
+		for _, tg := range m.scenarios {
+			m.log.Warn("scenario %s generated %d traces with %d spans\n", tg.name, tg.traces.Load(), tg.spans.Load())
+		}
+	}
+	if finished {
+		m.log.Info("stopping generator at end of schedule\n")
+	}
+}
+
+// TPS returns the rate at which the generator is currently trying to start traces.
+func (m *ScenarioMix) TPS() float64 {
+	return m.scheduler.CurrentRate()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_ScenarioOptions(t *testing.T) {
+	opts := newOptions()
+	opts.Output.Sender = "otel"
+	opts.Telemetry.Dataset = "shop"
+	opts.Format.Depth = 3
+	opts.Format.TraceTime = time.Second
+	opts.Fields["region"] = "us-east"
+	err := yaml.Unmarshal([]byte(`
+- name: checkout
+  tps: 5
+  dataset: payments
+  format: {depth: 5}
+  fields:
+    amount: /fr1,100
+- tps: 15
+`), &opts.Scenarios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateScenarios(opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Quantity.TPS != 20 || opts.Scenarios[0].Weight != 5 || opts.Scenarios[1].Name != "scenario2" {
+		t.Errorf("unexpected scenarios %+v %+v with tps %d", opts.Scenarios[0], opts.Scenarios[1], opts.Quantity.TPS)
+	}
+
+	scopts := opts.Scenarios[0].Options(opts)
+	if scopts.Telemetry.Dataset != "payments" || scopts.Format.Depth != 5 || scopts.Format.TraceTime != time.Second {
+		t.Errorf("unexpected scenario options %+v %+v", scopts.Telemetry, scopts.Format)
+	}
+	if len(scopts.Fields) != 2 || len(opts.Fields) != 1 {
+		t.Errorf("unexpected fields %v, top-level fields %v", scopts.Fields, opts.Fields)
+	}
+
+	opts.Output.Sender = "honeycomb"
+	if err := ValidateScenarios(opts); err == nil {
+		t.Errorf("expected an error for honeycomb scenarios with different datasets")
+	}
+	opts.Output.Sender = "otel"
+	opts.Scenarios[1].TPS = 0
+	opts.Scenarios[1].Weight = 0
+	if err := ValidateScenarios(opts); err == nil {
+		t.Errorf("expected an error when only some scenarios have tps")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a start that happens more than this long after its scheduled time is counted as late
+const lateThreshold = 10 * time.Millisecond
+
+// the scheduler never sleeps longer than this, so that it notices changes in the target rate
+const maxSchedulerSleep = 50 * time.Millisecond
+
+// Scheduler starts traces on an open-loop schedule: when a trace is started
+// depends only on the target rate, never on how long earlier traces took to
+// finish. Each trace runs in its own goroutine; no more than maxInFlight of
+// them may be running at once, and any start that would exceed that limit is
+// skipped (and counted) rather than delayed.
+//
+// The target rate follows a RateProfile and may change continuously. The
+// scheduler integrates it to get the number of traces that should have been
+// started so far, and the ArrivalProcess decides at which of those values
+// traces actually start, so it controls jitter without changing the mean rate.
+type Scheduler struct {
+	profile     *RateProfile
+	arrivals    ArrivalProcess
+	backfill    time.Duration
+	maxInFlight int
+	log         Logger
+	sem         chan struct{}
+	inflight    sync.WaitGroup
+	startTime   time.Time
+	currentRate atomic.Uint64 // float64 bits
+	launched    atomic.Int64
+	late        atomic.Int64
+	skipped     atomic.Int64
+}
+
+func NewScheduler(log Logger, opts *Options) *Scheduler {
+	profile := DefaultRateProfile(opts)
+	if opts.replay != nil {
+		profile = opts.replay.RateProfile(opts)
+	}
+	if opts.Quantity.Profile != "" {
+		var err error
+		profile, err = NewRateProfile(opts.Quantity.Profile)
+		if err != nil {
+			log.Fatal("unable to parse rate profile: %s\n", err)
+		}
+	}
+	log.Info("rate profile: %s\n", profile)
+	arrivals, err := NewArrivalProcess(opts)
+	if err != nil {
+		log.Fatal("unable to set up arrivals: %s\n", err)
+	}
+	s := &Scheduler{
+		profile:     profile,
+		arrivals:    arrivals,
+		backfill:    opts.Quantity.Backfill,
+		maxInFlight: opts.Quantity.MaxInFlight,
+		log:         log,
+	}
+	if s.maxInFlight > 0 {
+		s.sem = make(chan struct{}, s.maxInFlight)
+	}
+	return s
+}
+
+// CurrentRate returns the target rate the scheduler is currently trying to achieve.
+func (s *Scheduler) CurrentRate() float64 {
+	return math.Float64frombits(s.currentRate.Load())
+}
+
+// Run starts traces by calling launch in a new goroutine for each one, passing
+// it the trace count received from counter and the time the trace starts. It
+// returns true if the schedule ran to completion, or false if it was
+// interrupted by stop being closed. In either case it waits for all traces in
+// flight to finish before returning.
+func (s *Scheduler) Run(stop chan struct{}, counter chan int64, launch func(count int64, start time.Time)) bool {
+	defer s.inflight.Wait()
+	defer func() {
+		s.log.Warn("scheduler started %d traces (%d late, %d skipped at in-flight limit of %d)\n",
+			s.launched.Load(), s.late.Load(), s.skipped.Load(), s.maxInFlight)
+	}()
+	if s.backfill > 0 {
+		return s.runBackfill(stop, counter, launch)
+	}
+
+	s.startTime = time.Now()
+	lastTime := s.startTime
+	lastRate, _ := s.profile.Rate(0)
+	// expected is the number of traces that should have been started by lastTime;
+	// next is the value of expected at which the next burst of traces is due. The
+	// first burst is due as soon as the rate is above zero, so that a steady
+	// rate of N for one second starts N traces, not N-1.
+	expected := 0.0
+	next, burst := s.firstArrival()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		rate, ok := s.profile.Rate(now.Sub(s.startTime))
+		if !ok {
+			return true
+		}
+		s.currentRate.Store(math.Float64bits(rate))
+
+		// trapezoidal integration of the rate since the last time we woke up
+		dt := now.Sub(lastTime).Seconds()
+		increment := (lastRate + rate) / 2 * dt
+		for expected+increment >= next && (increment > 0 || rate > 0) {
+			// estimate when this trace should have started so we can tell if we're behind
+			due := now
+			if increment > 0 {
+				due = lastTime.Add(time.Duration((next - expected) / increment * float64(now.Sub(lastTime))))
+			}
+			for range burst {
+				if !s.start(stop, counter, launch, now, due) {
+					return false
+				}
+			}
+			gap, n := s.arrivals.Next()
+			next += gap
+			burst = n
+		}
+		expected += increment
+		lastTime = now
+		lastRate = rate
+
+		// sleep until the next trace is due, assuming the rate holds steady
+		sleep := maxSchedulerSleep
+		if rate > 0 {
+			sleep = min(sleep, time.Duration((next-expected)/rate*float64(time.Second)))
+		}
+		timer.Reset(max(sleep, time.Millisecond))
+	}
+}
+
+// runBackfill walks a simulated clock from the backfill duration before now up
+// to now, starting traces with simulated start times at the rate the profile
+// calls for at each simulated moment. Nothing sleeps; instead of skipping
+// traces at the in-flight limit it waits for room, so it goes as fast as the
+// sender allows.
+func (s *Scheduler) runBackfill(stop chan struct{}, counter chan int64, launch func(count int64, start time.Time)) bool {
+	end := time.Now()
+	s.startTime = end.Add(-s.backfill)
+	simTime := s.startTime
+	lastReport := time.Now()
+	expected := 0.0
+	next, burst := s.firstArrival()
+	for {
+		rate, ok := s.profile.Rate(simTime.Sub(s.startTime))
+		if !ok {
+			return true
+		}
+		s.currentRate.Store(math.Float64bits(rate))
+
+		// step to the next arrival, but no more than a simulated second at a
+		// time so that we follow changes in the rate
+		step := time.Second
+		arrival := false
+		if rate > 0 {
+			if toNext := time.Duration((next - expected) / rate * float64(time.Second)); toNext <= step {
+				step = toNext
+				arrival = true
+			}
+		}
+		simTime = simTime.Add(step)
+		if !simTime.Before(end) {
+			return true
+		}
+		if !arrival {
+			expected += rate * step.Seconds()
+			continue
+		}
+
+		expected = next
+		for range burst {
+			if !s.start(stop, counter, launch, simTime, time.Time{}) {
+				return false
+			}
+		}
+		gap, n := s.arrivals.Next()
+		next += gap
+		burst = n
+
+		if time.Since(lastReport) > 10*time.Second {
+			s.log.Info("backfilled %d traces up to %s\n", s.launched.Load(), simTime.Format(time.RFC3339))
+			lastReport = time.Now()
+		}
+	}
+}
+
+// firstArrival returns the value of expected at which the first burst of
+// traces is due, and its size. It's due at once; only the gaps after it come
+// from the arrival process.
+func (s *Scheduler) firstArrival() (float64, int) {
+	_, burst := s.arrivals.Next()
+	return 0, burst
+}
+
+// start launches a single trace with the given start time unless the in-flight
+// limit has been reached. When backfilling, it waits for room instead. It
+// returns false if stop was closed while waiting. The trace is counted as late
+// if it actually starts too long after it was due; backfilled traces have no
+// due time, since they're never late.
+func (s *Scheduler) start(stop chan struct{}, counter chan int64, launch func(count int64, start time.Time), at, due time.Time) bool {
+	if s.sem != nil {
+		if s.backfill > 0 {
+			select {
+			case s.sem <- struct{}{}:
+			case <-stop:
+				return false
+			}
+		} else {
+			select {
+			case s.sem <- struct{}{}:
+			default:
+				s.skipped.Add(1)
+				return true
+			}
+		}
+	}
+
+	var count int64
+	select {
+	case <-stop:
+		if s.sem != nil {
+			<-s.sem
+		}
+		return false
+	case count = <-counter:
+	}
+
+	if !due.IsZero() && time.Since(due) > lateThreshold {
+		s.late.Add(1)
+	}
+	s.launched.Add(1)
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Done()
+		if s.sem != nil {
+			defer func() { <-s.sem }()
+		}
+		launch(count, at)
+	}()
+	return true
+}
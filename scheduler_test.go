@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestScheduler returns a scheduler for a profile, and a counter that
+// hands out trace counts after waiting delay for each one.
+func newTestScheduler(t *testing.T, profile string, maxInFlight int, backfill, delay time.Duration) (*Scheduler, chan struct{}, chan int64) {
+	t.Helper()
+	opts := newOptions()
+	opts.Quantity.Profile = profile
+	opts.Quantity.MaxInFlight = maxInFlight
+	opts.Quantity.Backfill = backfill
+	s := NewScheduler(NewLogger(0), opts)
+
+	stop := make(chan struct{})
+	counter := make(chan int64)
+	go func() {
+		for count := int64(1); ; count++ {
+			time.Sleep(delay)
+			select {
+			case counter <- count:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(stop) })
+	return s, stop, counter
+}
+
+func Test_SchedulerRun(t *testing.T) {
+	s, stop, counter := newTestScheduler(t, "const:100,500ms", 0, 0, 0)
+	var mut sync.Mutex
+	var starts []time.Time
+	begin := time.Now()
+	if !s.Run(stop, counter, func(count int64, start time.Time) {
+		mut.Lock()
+		defer mut.Unlock()
+		starts = append(starts, start)
+	}) {
+		t.Fatal("the schedule didn't run to completion")
+	}
+	// arrivals are due at 0, 10ms, ... 490ms
+	if n := s.launched.Load(); n < 49 || n > 50 || len(starts) != int(n) {
+		t.Errorf("expected 50 traces, launched %d and started %d", n, len(starts))
+	}
+	if s.skipped.Load() != 0 {
+		t.Errorf("expected no skipped traces, got %d", s.skipped.Load())
+	}
+	for _, start := range starts {
+		if start.Sub(begin) < 0 || start.Sub(begin) > 500*time.Millisecond {
+			t.Errorf("trace started at %v, outside the profile", start.Sub(begin))
+		}
+	}
+}
+
+func Test_SchedulerInFlight(t *testing.T) {
+	// no trace finishes until the schedule is over, so only the first two start
+	s, stop, counter := newTestScheduler(t, "const:100,300ms", 2, 0, 0)
+	release := make(chan struct{})
+	go func() {
+		time.Sleep(400 * time.Millisecond)
+		close(release)
+	}()
+	s.Run(stop, counter, func(int64, time.Time) { <-release })
+	if s.launched.Load() != 2 {
+		t.Errorf("expected 2 traces at the in-flight limit, started %d", s.launched.Load())
+	}
+	if total := s.launched.Load() + s.skipped.Load(); total < 29 || total > 30 {
+		t.Errorf("expected 30 traces due, started %d and skipped %d", s.launched.Load(), s.skipped.Load())
+	}
+}
+
+func Test_SchedulerLate(t *testing.T) {
+	// each trace count takes 30ms to arrive, three times the gap between traces,
+	// so the scheduler falls further behind with every trace
+	s, stop, counter := newTestScheduler(t, "const:100,200ms", 0, 0, 30*time.Millisecond)
+	s.Run(stop, counter, func(int64, time.Time) {})
+	if s.launched.Load() == 0 || s.late.Load() < s.launched.Load()-1 {
+		t.Errorf("expected traces after the first to be late, started %d with %d late", s.launched.Load(), s.late.Load())
+	}
+}
+
+func Test_SchedulerBackfill(t *testing.T) {
+	s, stop, counter := newTestScheduler(t, "const:50", 0, 10*time.Second, 0)
+	var mut sync.Mutex
+	starts := make(map[int64]time.Time)
+	begin := time.Now()
+	if !s.Run(stop, counter, func(count int64, start time.Time) {
+		mut.Lock()
+		defer mut.Unlock()
+		starts[count] = start
+	}) {
+		t.Fatal("the backfill didn't run to completion")
+	}
+	end := time.Now()
+	if len(starts) != 500 {
+		t.Errorf("expected 500 traces over 10s, got %d", len(starts))
+	}
+	// counts are handed out in the order traces are scheduled
+	var last time.Time
+	for count := int64(1); count <= int64(len(starts)); count++ {
+		start := starts[count]
+		if start.Before(begin.Add(-10*time.Second)) || !start.Before(end) {
+			t.Errorf("trace %d started at %v, outside the backfill", count, start)
+		}
+		if !start.After(last) {
+			t.Errorf("trace %d started at %v, not after %v", count, start, last)
+		}
+		last = start
+	}
+}
+
+func Test_SchedulerBackfillInFlight(t *testing.T) {
+	// backfilling waits for room at the in-flight limit rather than skipping
+	s, stop, counter := newTestScheduler(t, "const:50", 1, time.Second, 0)
+	var running, most atomic.Int64
+	s.Run(stop, counter, func(int64, time.Time) {
+		most.Store(max(most.Load(), running.Add(1)))
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+	})
+	if s.launched.Load() != 50 || s.skipped.Load() != 0 {
+		t.Errorf("expected 50 traces and none skipped, started %d and skipped %d", s.launched.Load(), s.skipped.Load())
+	}
+	if most.Load() != 1 {
+		t.Errorf("expected 1 trace in flight at a time, got %d", most.Load())
+	}
+}
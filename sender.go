@@ -25,12 +25,26 @@ type ObsoleteSender interface {
 	Run(wg *sync.WaitGroup, spans chan *Span, stop chan struct{})
 }
 
+// A Sendable is a span that has been created but not sent; Send completes it
+// using the end time from its plan.
 type Sendable interface {
 	Send()
 }
 
+// A Sender turns planned spans into telemetry. CreateTrace is called for the
+// root span of each trace and CreateSpan for each of its descendants, always
+// with the context returned for the span's parent. The IDs, names and
+// timestamps of each span come from its plan, not from the sender.
 type Sender interface {
-	CreateTrace(ctx context.Context, name string, fielder *Fielder, count int64) (context.Context, Sendable)
-	CreateSpan(ctx context.Context, name string, level int, fielder *Fielder) (context.Context, Sendable)
+	CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable)
+	CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable)
 	Close()
 }
+
+// A DeliveryCounter is a sender that knows how many of its spans were
+// delivered, and how many weren't because they failed, were rejected or were
+// dropped. Senders that turn spans into events or log records count those
+// instead. The counts are only final once the sender has been closed.
+type DeliveryCounter interface {
+	Delivered() (delivered, failed int64)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 )
 
 type DummySender struct {
@@ -44,29 +45,35 @@ func (s DummySendable) Send() {
 }
 
 type SenderDummy struct {
-	tracecount int
-	nspans     int
+	tracecount atomic.Int64
+	nspans     atomic.Int64
 	log        Logger
 }
 
 // make sure it implements Sender
 var _ Sender = (*SenderDummy)(nil)
+var _ DeliveryCounter = (*SenderDummy)(nil)
 
 func NewSenderDummy(log Logger, opts *Options) Sender {
 	return &SenderDummy{log: log}
 }
 
 func (t *SenderDummy) Close() {
-	t.log.Warn("sender sent %d traces with %d spans\n", t.tracecount, t.nspans)
+	t.log.Warn("sender sent %d traces with %d spans\n", t.tracecount.Load(), t.nspans.Load())
 }
 
-func (t *SenderDummy) CreateTrace(ctx context.Context, name string, fielder *Fielder, count int64) (context.Context, Sendable) {
-	t.tracecount++
-	t.nspans++
+// Delivered returns the number of spans created, since none of them can fail.
+func (t *SenderDummy) Delivered() (int64, int64) {
+	return t.nspans.Load(), 0
+}
+
+func (t *SenderDummy) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	t.tracecount.Add(1)
+	t.nspans.Add(1)
 	return ctx, DummySendable{}
 }
 
-func (t *SenderDummy) CreateSpan(ctx context.Context, name string, level int, fielder *Fielder) (context.Context, Sendable) {
-	t.nspans++
+func (t *SenderDummy) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	t.nspans.Add(1)
 	return ctx, DummySendable{}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderEvents)(nil)
+var _ DeliveryCounter = (*SenderEvents)(nil)
+
+// batchEvent is an event in a batch sent to the Honeycomb batch endpoint.
+type batchEvent struct {
+	Time       time.Time      `json:"time" msgpack:"time"`
+	SampleRate int            `json:"samplerate" msgpack:"samplerate"`
+	Data       map[string]any `json:"data" msgpack:"data"`
+}
+
+// batchResponse is the result for one event in a batch.
+type batchResponse struct {
+	Status int    `json:"status" msgpack:"status"`
+	Error  string `json:"error,omitempty" msgpack:"error,omitempty"`
+}
+
+// SenderEvents sends spans to the Honeycomb Events API's batch endpoint
+// directly, in the same format as the honeycomb sender, but without the
+// beeline or libhoney. It has no global state, so any number of them can run
+// at once.
+type SenderEvents struct {
+	log     Logger
+	dataset string
+	msgpack bool
+	poster  *httpPoster
+	queue   *batchQueue[batchEvent]
+	stats   eventsStats
+}
+
+// eventsStats counts what happened to the events and batches sent.
+type eventsStats struct {
+	batches  atomic.Int64
+	events   atomic.Int64
+	accepted atomic.Int64
+	rejected atomic.Int64
+	failed   atomic.Int64 // in batches that couldn't be sent, or whose response couldn't be read
+	mut      sync.Mutex
+	statuses map[int]int64 // the number of batches with each HTTP status
+}
+
+func (s *eventsStats) addStatus(status int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.statuses[status]++
+}
+
+func NewSenderEvents(log Logger, opts *Options) *SenderEvents {
+	t := &SenderEvents{
+		log:     log,
+		dataset: opts.Telemetry.Dataset,
+		msgpack: opts.Output.EventsFormat == "msgpack",
+		poster: newHTTPPoster(log, opts,
+			opts.apihost.JoinPath("1", "batch", url.PathEscape(opts.Telemetry.Dataset)).String(),
+			map[string]string{"X-Honeycomb-Team": opts.Telemetry.APIKey},
+			compression(opts, "eventsapi")),
+	}
+	t.stats.statuses = make(map[int]int64)
+	t.queue = newBatchQueue(log, opts, t.send)
+	return t
+}
+
+// EventsSendable is the events for a span, which are queued when it's sent.
+type EventsSendable struct {
+	sender *SenderEvents
+	events []honeycombEvent
+}
+
+func (s EventsSendable) Send() {
+	for _, ev := range s.events {
+		s.sender.queue.add(batchEvent{Time: ev.Time, SampleRate: 1, Data: ev.Data})
+	}
+}
+
+// encode serializes a batch, returning the body and its content type.
+func (t *SenderEvents) encode(batch []batchEvent) ([]byte, string, error) {
+	if t.msgpack {
+		body, err := msgpack.Marshal(batch)
+		return body, "application/msgpack", err
+	}
+	body, err := json.Marshal(batch)
+	return body, "application/json", err
+}
+
+// send posts a batch and records the results.
+func (t *SenderEvents) send(batch []batchEvent) {
+	t.stats.batches.Add(1)
+	t.stats.events.Add(int64(len(batch)))
+	body, contentType, err := t.encode(batch)
+	if err != nil {
+		t.log.Error("unable to encode batch of %d events: %v\n", len(batch), err)
+		t.stats.failed.Add(int64(len(batch)))
+		return
+	}
+
+	resp, err := t.poster.post(body, contentType)
+	if err != nil {
+		t.log.Error("batch of %d events failed: %v\n", len(batch), err)
+		t.stats.failed.Add(int64(len(batch)))
+		return
+	}
+	defer resp.Body.Close()
+	t.stats.addStatus(resp.StatusCode)
+	if err := checkResponse(resp); err != nil {
+		t.log.Error("batch of %d events failed with %v\n", len(batch), err)
+		t.stats.failed.Add(int64(len(batch)))
+		return
+	}
+
+	// the response has a status for each event
+	var results []batchResponse
+	if resp.Header.Get("Content-Type") == "application/msgpack" {
+		err = msgpack.NewDecoder(resp.Body).Decode(&results)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&results)
+	}
+	if err != nil {
+		// we can't tell which events were accepted, so count them all as failed
+		t.log.Error("unable to read response to batch of %d events: %v\n", len(batch), err)
+		t.stats.failed.Add(int64(len(batch)))
+		return
+	}
+	accepted := 0
+	for _, r := range results {
+		if r.Status == http.StatusAccepted {
+			accepted++
+		} else if r.Error != "" {
+			t.log.Debug("event rejected with status %d: %s\n", r.Status, r.Error)
+		}
+	}
+	t.stats.accepted.Add(int64(accepted))
+	t.stats.rejected.Add(int64(len(results) - accepted))
+	if missing := len(batch) - len(results); missing > 0 {
+		t.log.Error("response to batch of %d events has only %d statuses\n", len(batch), len(results))
+		t.stats.failed.Add(int64(missing))
+	}
+	t.log.Info("batch of %d events: status %d, %d accepted, %d rejected\n", len(batch), resp.StatusCode, accepted, len(results)-accepted)
+}
+
+func (t *SenderEvents) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(count, 0), fielder.GetEvents(span))
+}
+
+func (t *SenderEvents) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))
+}
+
+func (t *SenderEvents) newSendable(span *SpanPlan, fields map[string]any, events []SpanEvent) EventsSendable {
+	main, annotations := honeycombEvents(span, t.dataset, fields, events)
+	return EventsSendable{sender: t, events: append([]honeycombEvent{main}, annotations...)}
+}
+
+// Close sends everything that's queued, then reports what happened to it.
+func (t *SenderEvents) Close() {
+	t.queue.close()
+	s := &t.stats
+	t.log.Warn("sender sent %d events in %d batches: %d accepted, %d rejected, %d failed, %d dropped\n",
+		s.events.Load(), s.batches.Load(), s.accepted.Load(), s.rejected.Load(), s.failed.Load(), t.queue.dropped.Load())
+	t.poster.bytes.report(t.log)
+	statuses := make([]int, 0, len(s.statuses))
+	for status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		t.log.Info("%d batches got status %d\n", s.statuses[status], status)
+	}
+	if t.queue.dropped.Load() > 0 {
+		t.log.Warn("events were dropped because the queue of %d was full; try --maxqueuesize or --concurrency\n", cap(t.queue.queue))
+	}
+}
+
+// Delivered returns the number of events accepted, and the number that were
+// rejected, failed or were dropped.
+func (t *SenderEvents) Delivered() (int64, int64) {
+	s := &t.stats
+	return s.accepted.Load(), s.rejected.Load() + s.failed.Load() + t.queue.dropped.Load()
+}
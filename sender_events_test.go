@@ -0,0 +1,104 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func Test_SenderEvents(t *testing.T) {
+	for _, tt := range []struct {
+		format, compression string
+	}{{"json", "none"}, {"json", "zstd"}, {"msgpack", "gzip"}} {
+		t.Run(tt.format+"/"+tt.compression, func(t *testing.T) {
+			var requests, received atomic.Int64
+			host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				// fail the first request, to check that it's retried
+				if requests.Add(1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				if r.URL.Path != "/1/batch/my data" {
+					t.Errorf("unexpected path %s", r.URL.EscapedPath())
+				}
+				if r.Header.Get("X-Honeycomb-Team") != "key" {
+					t.Errorf("missing api key")
+				}
+				var body io.Reader = r.Body
+				switch r.Header.Get("Content-Encoding") {
+				case "gzip":
+					body, _ = gzip.NewReader(r.Body)
+				case "zstd":
+					zr, _ := zstd.NewReader(r.Body)
+					defer zr.Close()
+					body = zr
+				}
+				var batch []batchEvent
+				var err error
+				if r.Header.Get("Content-Type") == "application/msgpack" {
+					err = msgpack.NewDecoder(body).Decode(&batch)
+				} else {
+					err = json.NewDecoder(body).Decode(&batch)
+				}
+				if err != nil {
+					t.Errorf("unable to decode batch: %v", err)
+				}
+				results := make([]batchResponse, len(batch))
+				for i, ev := range batch {
+					if ev.Data["trace.trace_id"] == nil {
+						t.Errorf("event is missing its trace ID: %v", ev.Data)
+					}
+					results[i].Status = http.StatusAccepted
+				}
+				received.Add(int64(len(batch)))
+				_ = json.NewEncoder(w).Encode(results)
+			})
+
+			opts := &Options{}
+			opts.apihost = host
+			opts.Telemetry.APIKey = "key"
+			opts.Telemetry.Dataset = "my data"
+			opts.Output.EventsFormat = tt.format
+			opts.Output.Compression = tt.compression
+			opts.Output.Concurrency = 2
+			opts.Output.Retries = 1
+			opts.Output.MaxExportBatchSize = 3
+			opts.Output.BatchTimeout = 10 * time.Millisecond
+			sender := NewSenderEvents(NewLogger(0), opts)
+			sendSpans(t, sender, 6)
+
+			if received.Load() != 7 || sender.stats.accepted.Load() != 7 {
+				t.Errorf("expected 7 events to be accepted, got %d received and %d accepted", received.Load(), sender.stats.accepted.Load())
+			}
+		})
+	}
+}
+
+func Test_SenderEventsBadResponse(t *testing.T) {
+	for _, body := range []string{"not json", "[]"} {
+		host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, body)
+		})
+
+		opts := &Options{}
+		opts.apihost = host
+		opts.Output.EventsFormat = "json"
+		opts.Output.Compression = "none"
+		opts.Output.Concurrency = 1
+		opts.Output.MaxExportBatchSize = 3
+		opts.Output.BatchTimeout = 10 * time.Millisecond
+		sender := NewSenderEvents(NewLogger(0), opts)
+		sendSpans(t, sender, 6)
+
+		if delivered, failed := sender.Delivered(); delivered != 0 || failed != 7 {
+			t.Errorf("%q: expected 7 events to fail, got %d delivered and %d failed", body, delivered, failed)
+		}
+	}
+}
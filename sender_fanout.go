@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderFanout)(nil)
+
+// A Destination is one of several places that the same traces are sent to.
+// Destinations are specified in the config file; any option left unset in a
+// destination is taken from the top-level options, and its headers are added
+// to the top-level ones.
+type Destination struct {
+	Name        string            `yaml:"name"`
+	Sender      string            `yaml:"sender,omitempty"`
+	Host        string            `yaml:"host,omitempty"`
+	Insecure    bool              `yaml:"insecure,omitempty"`
+	Protocol    string            `yaml:"protocol,omitempty"`
+	APIKey      string            `yaml:"apikey,omitempty"`
+	Dataset     string            `yaml:"dataset,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	Compression string            `yaml:"compression,omitempty"`
+}
+
+// ValidateDestinations checks the destinations in opts, including that their
+// senders support their compression, fills in their names, and expands the
+// environment variables in their headers. Only one destination can use the
+// honeycomb sender, because the beeline is global.
+func ValidateDestinations(opts *Options) error {
+	names := make(map[string]bool)
+	honeycomb := 0
+	for i, d := range opts.Destinations {
+		if d.Name == "" {
+			d.Name = fmt.Sprintf("destination%d", i+1)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("duplicate destination name %s", d.Name)
+		}
+		names[d.Name] = true
+		if err := expandHeaders(d.Headers); err != nil {
+			return fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		sender := d.Sender
+		if sender == "" {
+			sender = opts.Output.Sender
+		}
+		if sender == "honeycomb" {
+			honeycomb++
+		}
+		codec := d.Compression
+		if codec == "" {
+			codec = opts.Output.Compression
+		}
+		if err := ValidateCompression(sender, codec, opts.metrics()); err != nil {
+			return fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+	}
+	if honeycomb > 1 {
+		return fmt.Errorf("only one destination can use the honeycomb sender; use eventsapi for the others")
+	}
+	return nil
+}
+
+// Options returns a copy of base with the destination's settings applied.
+func (d *Destination) Options(log Logger, base *Options) *Options {
+	opts := *base
+	opts.Destinations = nil
+	if d.Sender != "" {
+		opts.Output.Sender = d.Sender
+	}
+	if d.Protocol != "" {
+		opts.Output.Protocol = d.Protocol
+	}
+	if d.Compression != "" {
+		opts.Output.Compression = d.Compression
+	}
+	if d.APIKey != "" {
+		opts.Telemetry.APIKey = d.APIKey
+	}
+	if d.Dataset != "" {
+		opts.Telemetry.Dataset = d.Dataset
+	}
+	if d.Host != "" {
+		opts.Telemetry.Host = d.Host
+		opts.Telemetry.Insecure = d.Insecure
+		opts.apihost = parseHost(log, d.Host, d.Insecure)
+	}
+	opts.Telemetry.Headers = make(map[string]string)
+	for k, v := range base.Telemetry.Headers {
+		opts.Telemetry.Headers[k] = v
+	}
+	for k, v := range d.Headers {
+		// replace a top-level header even if its name is written differently
+		for name := range opts.Telemetry.Headers {
+			if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(k) {
+				delete(opts.Telemetry.Headers, name)
+			}
+		}
+		opts.Telemetry.Headers[k] = v
+	}
+	return &opts
+}
+
+// SenderFanout sends every span to each of several destinations, through a
+// sender of its own, so that they all get identical traces: the same IDs and
+// timestamps, and the same fields and events. Fields and events are generated
+// once for each span and added to a copy of its plan, which is what the
+// destinations' senders get, along with a fielder that adds nothing else.
+// Each sender reports what happened to its spans when it's closed, with the
+// name of its destination, and then the fanout sums up how many each
+// destination delivered and how many it failed to.
+type SenderFanout struct {
+	log     Logger
+	names   []string
+	senders []Sender
+	traces  atomic.Int64
+	spans   atomic.Int64
+}
+
+// the destinations' senders get their fields from the plan, so their fielder
+// has none (but still adds the count to root spans)
+var noFields = &Fielder{}
+
+// fanoutKey is the context key for the contexts that each destination's
+// sender returned for a span, which are passed back to it for the span's
+// children.
+type fanoutKey struct{}
+
+func NewSenderFanout(log Logger, opts *Options) *SenderFanout {
+	t := &SenderFanout{log: log}
+	for _, d := range opts.Destinations {
+		dlog := WithPrefix(log, "["+d.Name+"] ")
+		t.names = append(t.names, d.Name)
+		t.senders = append(t.senders, newSender(dlog, d.Options(dlog, opts)))
+	}
+	return t
+}
+
+// FanoutSendable is a span created by every destination's sender.
+type FanoutSendable []Sendable
+
+func (s FanoutSendable) Send() {
+	for _, sendable := range s {
+		sendable.Send()
+	}
+}
+
+// fixed returns a copy of the plan for a span, with the fields and events
+// generated for it added as attributes and events.
+func fixed(span *SpanPlan, fielder *Fielder, level int) *SpanPlan {
+	c := *span
+	c.Attributes = fielder.GetFields(0, level)
+	for k, v := range span.Attributes {
+		c.Attributes[k] = v
+	}
+	c.Events = fielder.GetEvents(span)
+	return &c
+}
+
+// create creates a span with each destination's sender, in the context that
+// sender returned for the span's parent.
+func (t *SenderFanout) create(ctx context.Context, create func(Sender, context.Context) (context.Context, Sendable)) (context.Context, Sendable) {
+	t.spans.Add(1)
+	parents, _ := ctx.Value(fanoutKey{}).([]context.Context)
+	ctxs := make([]context.Context, len(t.senders))
+	sendables := make(FanoutSendable, len(t.senders))
+	for i, sender := range t.senders {
+		parent := ctx
+		if parents != nil {
+			parent = parents[i]
+		}
+		ctxs[i], sendables[i] = create(sender, parent)
+	}
+	return context.WithValue(ctx, fanoutKey{}, ctxs), sendables
+}
+
+func (t *SenderFanout) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	t.traces.Add(1)
+	span = fixed(span, fielder, 0)
+	return t.create(ctx, func(sender Sender, ctx context.Context) (context.Context, Sendable) {
+		return sender.CreateTrace(ctx, span, noFields, count)
+	})
+}
+
+func (t *SenderFanout) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	span = fixed(span, fielder, span.Level)
+	return t.create(ctx, func(sender Sender, ctx context.Context) (context.Context, Sendable) {
+		return sender.CreateSpan(ctx, span, noFields)
+	})
+}
+
+// Close closes every destination's sender, which reports how its spans fared,
+// then reports the delivered and failed counts of every destination together.
+func (t *SenderFanout) Close() {
+	for _, sender := range t.senders {
+		sender.Close()
+	}
+	t.log.Warn("sent %d traces with %d spans to each of %d destinations\n", t.traces.Load(), t.spans.Load(), len(t.senders))
+	for i, sender := range t.senders {
+		if dc, ok := sender.(DeliveryCounter); ok {
+			delivered, failed := dc.Delivered()
+			t.log.Warn("destination %s: %d delivered, %d failed\n", t.names[i], delivered, failed)
+		} else {
+			t.log.Warn("destination %s: delivery not counted\n", t.names[i])
+		}
+	}
+}
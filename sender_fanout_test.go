@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSender records the spans it's given, with their fields and the
+// name of the span whose context each was created in.
+type recordingSender struct {
+	mut   sync.Mutex
+	spans []recordedSpan
+	sent  int
+}
+
+type recordedSpan struct {
+	plan   *SpanPlan
+	fields map[string]any
+	events []SpanEvent
+	parent string
+}
+
+type recordingKey struct{}
+
+func (r *recordingSender) record(ctx context.Context, span *SpanPlan, fields map[string]any, events []SpanEvent) (context.Context, Sendable) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	parent, _ := ctx.Value(recordingKey{}).(string)
+	r.spans = append(r.spans, recordedSpan{plan: span, fields: fields, events: events, parent: parent})
+	return context.WithValue(ctx, recordingKey{}, span.Name), r
+}
+
+func (r *recordingSender) Send() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.sent++
+}
+
+func (r *recordingSender) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return r.record(ctx, span, fielder.GetFields(count, 0), fielder.GetEvents(span))
+}
+
+func (r *recordingSender) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return r.record(ctx, span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))
+}
+
+func (r *recordingSender) Close() {}
+
+func Test_SenderFanout(t *testing.T) {
+	a, b := &recordingSender{}, &recordingSender{}
+	sender := &SenderFanout{log: NewLogger(0), names: []string{"a", "b"}, senders: []Sender{a, b}}
+	f, err := NewFielder("hello", map[string]string{"user": "/sw10", "1.depth": "/i100"}, nil, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetEvents(2, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	root := newRootPlan("root", start)
+	root.End = start.Add(10 * time.Millisecond)
+	root.setAttribute("planned", "yes")
+	child := root.addChild("child", start)
+	child.End = root.End
+	ctx, rs := sender.CreateTrace(context.Background(), root, f, 7)
+	_, cs := sender.CreateSpan(ctx, child, f)
+	cs.Send()
+	rs.Send()
+
+	if len(a.spans) != 2 || a.sent != 2 || b.sent != 2 {
+		t.Fatalf("expected 2 spans sent to each destination, got %d and %d", a.sent, b.sent)
+	}
+	if !reflect.DeepEqual(a.spans, b.spans) {
+		t.Errorf("destinations got different spans:\n%+v\n%+v", a.spans, b.spans)
+	}
+	got := a.spans[0]
+	if got.plan.TraceID != root.TraceID || got.plan.SpanID != root.SpanID || got.fields["count"] != int64(7) {
+		t.Errorf("unexpected root %+v", got)
+	}
+	if got.plan.Attributes["planned"] != "yes" || got.plan.Attributes["user"] == nil || len(got.events) != 2 {
+		t.Errorf("root is missing attributes or events: %+v", got)
+	}
+	if got := a.spans[1]; got.parent != "root" || got.plan.Attributes["depth"] == nil {
+		t.Errorf("unexpected child %+v", got)
+	}
+	if len(root.Attributes) != 1 || root.Events != nil {
+		t.Errorf("the original plan was changed: %+v", root)
+	}
+}
+
+func Test_Destinations(t *testing.T) {
+	opts := &Options{}
+	opts.Output.Sender = "honeycomb"
+	opts.Telemetry.Headers = map[string]string{"x-a": "1", "X-B": "0"}
+	opts.Destinations = []*Destination{
+		{Host: "http://localhost:4318", Protocol: "http", Sender: "otel", Headers: map[string]string{"x-b": "2"}},
+		{},
+	}
+	if err := ValidateDestinations(opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Destinations[0].Name != "destination1" || opts.Destinations[1].Name != "destination2" {
+		t.Errorf("unexpected names %s, %s", opts.Destinations[0].Name, opts.Destinations[1].Name)
+	}
+
+	dopts := opts.Destinations[0].Options(NewLogger(0), opts)
+	if dopts.Output.Sender != "otel" || dopts.Output.Protocol != "http" || dopts.apihost.Host != "localhost:4318" || dopts.Destinations != nil {
+		t.Errorf("destination options weren't applied: %+v", dopts)
+	}
+	if !reflect.DeepEqual(dopts.Telemetry.Headers, map[string]string{"x-a": "1", "x-b": "2"}) || len(opts.Telemetry.Headers) != 2 {
+		t.Errorf("unexpected headers %v", dopts.Telemetry.Headers)
+	}
+
+	opts.Destinations[0].Sender = ""
+	if err := ValidateDestinations(opts); err == nil {
+		t.Errorf("expected an error for two honeycomb destinations")
+	}
+}
+
+func Test_SenderFanoutDelivered(t *testing.T) {
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	opts := &Options{}
+	opts.apihost = host
+	opts.Output.ZipkinPath = "/api/v2/spans"
+	opts.Output.Concurrency = 1
+	opts.Destinations = []*Destination{
+		{Name: "dummy", Sender: "dummy"},
+		{Name: "zipkin", Sender: "zipkin"},
+	}
+	sender := NewSenderFanout(NewLogger(0), opts)
+	sendSpans(t, sender, 2)
+
+	expected := [][2]int64{{3, 0}, {0, 3}}
+	for i, s := range sender.senders {
+		delivered, failed := s.(DeliveryCounter).Delivered()
+		if delivered != expected[i][0] || failed != expected[i][1] {
+			t.Errorf("%s: expected %d delivered and %d failed, got %d and %d",
+				sender.names[i], expected[i][0], expected[i][1], delivered, failed)
+		}
+	}
+}
+
+func Test_SenderOTelDelivered(t *testing.T) {
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	})
+	opts := &Options{}
+	opts.apihost = host
+	opts.Telemetry.Insecure = true
+	opts.Output.Sender = "otel"
+	opts.Output.Protocol = "http"
+	// the exporter is slow and the queue has room for one span, so most are dropped
+	opts.Output.MaxQueueSize = 1
+	opts.Output.MaxExportBatchSize = 1
+	sender := NewSenderOTel(NewLogger(0), opts)
+	sendSpans(t, sender, 19)
+
+	delivered, failed := sender.Delivered()
+	if delivered+failed != 20 || failed == 0 {
+		t.Errorf("expected 20 spans with some dropped, got %d delivered and %d failed", delivered, failed)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderFile)(nil)
+var _ DeliveryCounter = (*SenderFile)(nil)
+
+// fileSpan is a span waiting to be written, with the service whose resource
+// it belongs to.
+type fileSpan struct {
+	service string
+	span    *tracepb.Span
+}
+
+// SenderFile writes spans to a file, or to stdout, as OTLP: either OTLP/JSON,
+// with one ExportTraceServiceRequest per line, or OTLP protobuf, with each
+// request preceded by its length as a varint. Each batch of spans is one
+// request. Optionally the output is gzipped, and the file is rotated when it
+// reaches a maximum size: the full file is renamed with a sequence number, as
+// FILE.1, FILE.2 and so on, and a new one started.
+type SenderFile struct {
+	log        Logger
+	path       string // "-" for stdout
+	json       bool
+	gzip       bool
+	maxSize    int64
+	dataset    string
+	perService bool
+	resources  *ResourceGenerator
+	queue      *batchQueue[fileSpan]
+
+	mut      sync.Mutex // protects everything below
+	file     *os.File
+	buf      *bufio.Writer
+	zw       *gzip.Writer
+	w        io.Writer
+	size     int64 // bytes written to the current file, before compression
+	rotated  int
+	requests atomic.Int64
+	spans    atomic.Int64
+	failed   atomic.Int64 // in batches that couldn't be encoded
+}
+
+func NewSenderFile(log Logger, opts *Options) *SenderFile {
+	resources, err := NewResourceGenerator(opts.Global.Seed, opts.Output.ResourceAttrs)
+	if err != nil {
+		log.Fatal("unable to parse resource attributes: %v\n", err)
+	}
+	t := &SenderFile{
+		log:        log,
+		path:       opts.Output.File,
+		json:       opts.Output.FileFormat == "json",
+		gzip:       opts.Output.FileGzip,
+		maxSize:    opts.Output.FileMaxSize * 1024 * 1024,
+		dataset:    opts.Telemetry.Dataset,
+		perService: opts.Output.Resources == "service",
+		resources:  resources,
+	}
+	if err := t.open(); err != nil {
+		log.Fatal("unable to open output file: %v\n", err)
+	}
+
+	// write batches one at a time, so that they're in a consistent order
+	fopts := *opts
+	fopts.Output.Concurrency = 1
+	t.queue = newBatchQueue(log, &fopts, t.write)
+	// writing to a file can always keep up, so never drop spans
+	t.queue.block = true
+	return t
+}
+
+// open starts writing to the output file, truncating it.
+func (t *SenderFile) open() error {
+	if t.path == "-" {
+		t.file = os.Stdout
+	} else {
+		f, err := os.Create(t.path)
+		if err != nil {
+			return err
+		}
+		t.file = f
+	}
+	t.buf = bufio.NewWriter(t.file)
+	t.w = t.buf
+	if t.gzip {
+		t.zw = gzip.NewWriter(t.buf)
+		t.w = t.zw
+	}
+	t.size = 0
+	return nil
+}
+
+// closeFile flushes and closes the output file.
+func (t *SenderFile) closeFile() error {
+	if t.zw != nil {
+		if err := t.zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := t.buf.Flush(); err != nil {
+		return err
+	}
+	if t.file == os.Stdout {
+		return nil
+	}
+	return t.file.Close()
+}
+
+// rotate renames the output file with the next sequence number, and starts a
+// new one.
+func (t *SenderFile) rotate() error {
+	if err := t.closeFile(); err != nil {
+		return err
+	}
+	t.rotated++
+	if err := os.Rename(t.path, fmt.Sprintf("%s.%d", t.path, t.rotated)); err != nil {
+		return err
+	}
+	return t.open()
+}
+
+// request groups a batch of spans into an export request, with a resource for
+// each service (or just one for the dataset).
+func (t *SenderFile) request(batch []fileSpan) *coltracepb.ExportTraceServiceRequest {
+	req := &coltracepb.ExportTraceServiceRequest{}
+	scopes := make(map[string]*tracepb.ScopeSpans)
+	for _, fs := range batch {
+		ss, ok := scopes[fs.service]
+		if !ok {
+			ss = &tracepb.ScopeSpans{Scope: otlpScope()}
+			scopes[fs.service] = ss
+			req.ResourceSpans = append(req.ResourceSpans, &tracepb.ResourceSpans{
+				Resource:   otlpResource(fs.service, t.resources.Attributes(fs.service)),
+				ScopeSpans: []*tracepb.ScopeSpans{ss},
+			})
+		}
+		ss.Spans = append(ss.Spans, fs.span)
+	}
+	return req
+}
+
+// write writes a batch of spans as one request.
+func (t *SenderFile) write(batch []fileSpan) {
+	req := t.request(batch)
+	var data []byte
+	var err error
+	if t.json {
+		data, err = marshalOTLPJSON(req)
+		data = append(data, '\n')
+	} else {
+		var msg []byte
+		msg, err = proto.Marshal(req)
+		data = binary.AppendUvarint(nil, uint64(len(msg)))
+		data = append(data, msg...)
+	}
+	if err != nil {
+		t.log.Error("unable to encode batch of %d spans: %v\n", len(batch), err)
+		t.failed.Add(int64(len(batch)))
+		return
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.maxSize > 0 && t.size > 0 && t.size+int64(len(data)) > t.maxSize && t.path != "-" {
+		if err := t.rotate(); err != nil {
+			t.log.Fatal("unable to rotate output file: %v\n", err)
+		}
+	}
+	n, err := t.w.Write(data)
+	t.size += int64(n)
+	if err != nil {
+		t.log.Fatal("unable to write to output file: %v\n", err)
+	}
+	t.requests.Add(1)
+	t.spans.Add(int64(len(batch)))
+}
+
+func (t *SenderFile) newSendable(span *SpanPlan, fields map[string]any, events []SpanEvent) queuedSendable[fileSpan] {
+	service := t.dataset
+	if t.perService {
+		service = span.ServiceName()
+	} else if span.Service != "" {
+		// all spans share the dataset's resource, so record the service on the span
+		fields["service.name"] = span.Service
+	}
+	return queuedSendable[fileSpan]{queue: t.queue, item: fileSpan{service: service, span: otlpSpan(span, fields, events)}}
+}
+
+func (t *SenderFile) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(count, 0), fielder.GetEvents(span))
+}
+
+func (t *SenderFile) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))
+}
+
+// Close writes everything that's queued and closes the file.
+func (t *SenderFile) Close() {
+	t.queue.close()
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if err := t.closeFile(); err != nil {
+		t.log.Error("unable to close output file: %v\n", err)
+	}
+	t.log.Warn("sender wrote %d spans in %d requests: %d failed, %d dropped\n",
+		t.spans.Load(), t.requests.Load(), t.failed.Load(), t.queue.dropped.Load())
+}
+
+// Delivered returns the number of spans written, and the number that couldn't
+// be encoded or were dropped.
+func (t *SenderFile) Delivered() (int64, int64) {
+	return t.spans.Load(), t.failed.Load() + t.queue.dropped.Load()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestSenderFile(path, format string) *SenderFile {
+	opts := &Options{}
+	opts.Output.File = path
+	opts.Output.FileFormat = format
+	opts.Output.MaxExportBatchSize = 2
+	opts.Telemetry.Dataset = "test"
+	return NewSenderFile(NewLogger(0), opts)
+}
+
+// writeTraces sends n traces of two spans each through a file sender, which
+// writes each trace as one request.
+func writeTraces(t *testing.T, sender *SenderFile, n int) []*SpanPlan {
+	f, err := NewFielder("hello", nil, nil, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []*SpanPlan
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		root := newRootPlan("root", start)
+		root.End = start.Add(10 * time.Millisecond)
+		child := root.addChild("child", start)
+		child.End = root.End
+		_, s := sender.CreateTrace(context.Background(), root, f, 1)
+		s.Send()
+		_, s = sender.CreateSpan(context.Background(), child, f)
+		s.Send()
+		roots = append(roots, root)
+	}
+	sender.Close()
+	return roots
+}
+
+func Test_SenderFile_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	roots := writeTraces(t, newTestSenderFile(path, "json"), 2)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	spans := 0
+	traces := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var req coltracepb.ExportTraceServiceRequest
+		if err := unmarshalOTLPJSON(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans++
+					traces[string(span.TraceId)] = true
+				}
+			}
+		}
+	}
+	if spans != 4 {
+		t.Errorf("expected 4 spans, got %d", spans)
+	}
+	for _, root := range roots {
+		if !traces[string(root.TraceID[:])] {
+			t.Errorf("trace %s is missing", root.TraceID)
+		}
+	}
+}
+
+func Test_SenderFile_ProtoRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.pb")
+	sender := newTestSenderFile(path, "proto")
+	// rotate before every request but the first
+	sender.maxSize = 1
+	writeTraces(t, sender, 3)
+
+	for _, name := range []string{path + ".1", path + ".2", path} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, n := binary.Uvarint(data)
+		if n <= 0 || n+int(size) != len(data) {
+			t.Fatalf("%s should hold exactly one request", name)
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(data[n:], &req); err != nil {
+			t.Fatal(err)
+		}
+		if spans := req.ResourceSpans[0].ScopeSpans[0].Spans; len(spans) != 2 {
+			t.Errorf("expected 2 spans in %s, got %d", name, len(spans))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("unexpected rotation to %s.3", path)
+	}
+}
+
+func Test_unmarshalOTLPJSON(t *testing.T) {
+	// neither number survives a round trip through a float64
+	data := `{"resourceSpans": [{"scopeSpans": [{"spans": [{
+		"traceId": "0102030405060708090a0b0c0d0e0f10", "spanId": "0102030405060708",
+		"startTimeUnixNano": 1700000000123456789,
+		"attributes": [{"key": "n", "value": {"intValue": 9007199254740993}}]
+	}]}]}]}`
+	var req coltracepb.ExportTraceServiceRequest
+	if err := unmarshalOTLPJSON([]byte(data), &req); err != nil {
+		t.Fatal(err)
+	}
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.StartTimeUnixNano != 1700000000123456789 || span.Attributes[0].Value.GetIntValue() != 9007199254740993 {
+		t.Errorf("numbers lost precision: %d, %d", span.StartTimeUnixNano, span.Attributes[0].Value.GetIntValue())
+	}
+	if span.SpanId[7] != 8 {
+		t.Errorf("unexpected span ID %x", span.SpanId)
+	}
+}
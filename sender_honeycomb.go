@@ -2,43 +2,252 @@ package main
 
 import (
 	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/client"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type SenderHoneycomb struct{}
+// The beeline is global, so all honeycomb senders share it, the responses to
+// the events it sends and the count of their bytes; each sender has its own
+// builder so that it can send to its own dataset.
+var (
+	beelineInit, beelineClose sync.Once
+	beelineResponses          honeycombResponses
+	beelineBytes              *byteCounter
+)
+
+// honeycombResponses counts the responses to the events sent by the beeline's
+// client: the events that were accepted, and the ones that failed, were
+// rejected or were dropped because libhoney's queue was full.
+type honeycombResponses struct {
+	accepted atomic.Int64
+	failed   atomic.Int64
+	done     chan struct{}
+}
+
+// read counts responses until the client closes the channel.
+func (r *honeycombResponses) read(log Logger, responses chan transmission.Response) {
+	defer close(r.done)
+	for resp := range responses {
+		if resp.Err == nil && resp.StatusCode == http.StatusAccepted {
+			r.accepted.Add(1)
+			continue
+		}
+		r.failed.Add(1)
+		log.Debug("event failed with status %d: %v %s\n", resp.StatusCode, resp.Err, resp.Body)
+	}
+}
+
+// usesHoneycomb returns whether any traces are sent with the honeycomb sender.
+func usesHoneycomb(opts *Options) bool {
+	if len(opts.Destinations) == 0 {
+		return opts.Output.Sender == "honeycomb"
+	}
+	for _, d := range opts.Destinations {
+		if d.Sender == "honeycomb" || d.Sender == "" && opts.Output.Sender == "honeycomb" {
+			return true
+		}
+	}
+	return false
+}
+
+type SenderHoneycomb struct {
+	log     Logger
+	builder *libhoney.Builder
+}
 
 // make sure it implements Sender
 var _ Sender = (*SenderHoneycomb)(nil)
+var _ DeliveryCounter = (*SenderHoneycomb)(nil)
 
-func NewSenderHoneycomb(opts *Options) *SenderHoneycomb {
+// HoneycombSendable is a span built directly as a libhoney event on the
+// beeline's client, so that it can carry the timestamps from its plan; beeline
+// spans always start when they're created.
+type HoneycombSendable struct {
+	ev          *libhoney.Event
+	annotations []*libhoney.Event // links and span events
+}
+
+// Send sends the span's events. An event that libhoney refuses to send, for
+// instance because it has no API key, never gets a response, so it's counted
+// as failed here.
+func (s HoneycombSendable) Send() {
+	if s.ev.Send() != nil {
+		beelineResponses.failed.Add(1)
+	}
+	for _, ann := range s.annotations {
+		if ann.Send() != nil {
+			beelineResponses.failed.Add(1)
+		}
+	}
+}
+
+func NewSenderHoneycomb(log Logger, opts *Options) *SenderHoneycomb {
+	beelineInit.Do(func() { initBeeline(log, opts) })
+	builder := client.NewBuilder()
+	builder.Dataset = opts.Telemetry.Dataset
+	return &SenderHoneycomb{log: log, builder: builder}
+}
+
+// initBeeline sets up the beeline with a client of our own, so that we can read
+// the responses to its events. When backfilling we want to go as fast as
+// libhoney can, not drop events, which needs a client that blocks when its
+// queue is full; the TLS options need a transport that uses them; and libhoney
+// doesn't say how big its requests were, so it's told not to compress them,
+// and its transport compresses and counts them instead.
+func initBeeline(log Logger, opts *Options) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.tls != nil {
+		transport.TLSClientConfig = tlsConfig(opts)
+	}
+	beelineBytes = &byteCounter{codec: compression(opts, "honeycomb")}
+	tx := &transmission.Honeycomb{
+		MaxBatchSize:         libhoney.DefaultMaxBatchSize,
+		BatchTimeout:         libhoney.DefaultBatchTimeout,
+		MaxConcurrentBatches: libhoney.DefaultMaxConcurrentBatches,
+		PendingWorkCapacity:  libhoney.DefaultPendingWorkCapacity,
+		BlockOnSend:          opts.Quantity.Backfill > 0,
+		BlockOnResponse:      true, // we always read them, so we count them all
+		DisableCompression:   true,
+		Transport:            &compressingTransport{base: transport, bytes: beelineBytes},
+	}
+	config := libhoney.ClientConfig{
+		APIKey:       opts.Telemetry.APIKey,
+		APIHost:      opts.apihost.String(),
+		Transmission: tx,
+	}
+	if opts.DebugLevel() > 2 {
+		config.Logger = &libhoney.DefaultLogger{}
+	}
+	c, err := libhoney.NewClient(config)
+	if err != nil {
+		log.Fatal("unable to create honeycomb client: %v\n", err)
+	}
+	beelineResponses.done = make(chan struct{})
+	go beelineResponses.read(log, c.TxResponses())
+	// in debug mode the beeline would read the responses itself, so the client
+	// does the debug logging instead
 	beeline.Init(beeline.Config{
 		WriteKey:    opts.Telemetry.APIKey,
 		APIHost:     opts.apihost.String(),
 		ServiceName: opts.Telemetry.Dataset,
-		Debug:       opts.DebugLevel() > 2,
+		Client:      c,
 	})
-	return &SenderHoneycomb{}
 }
 
+// Close closes the beeline, which sends everything that's queued, then reports
+// what happened to the events it sent, and how many bytes they came to.
 func (t *SenderHoneycomb) Close() {
-	beeline.Close()
+	beelineClose.Do(func() {
+		beeline.Close()
+		<-beelineResponses.done
+		t.log.Warn("sender sent %d events: %d accepted, %d failed\n",
+			beelineResponses.accepted.Load()+beelineResponses.failed.Load(),
+			beelineResponses.accepted.Load(), beelineResponses.failed.Load())
+		beelineBytes.report(t.log)
+	})
 }
 
-func (t *SenderHoneycomb) CreateTrace(ctx context.Context, name string, fielder *Fielder, count int64) (context.Context, Sendable) {
-	// a beeline span is already a Sendable
-	ctx, root := beeline.StartSpan(ctx, name)
-	for k, v := range fielder.GetFields(count, 0) {
-		root.AddField(k, v)
+// Delivered returns the number of events that were accepted, and the number
+// that weren't.
+func (t *SenderHoneycomb) Delivered() (int64, int64) {
+	return beelineResponses.accepted.Load(), beelineResponses.failed.Load()
+}
+
+// A honeycombEvent is an event as the Honeycomb API takes it.
+type honeycombEvent struct {
+	Time time.Time
+	Data map[string]any
+}
+
+// honeycombEvents returns the event for a span, with the standard beeline trace
+// fields, and the events for its links and span events, which are sent
+// separately, the way the beelines send them. Spans without a service are
+// attributed to the dataset.
+func honeycombEvents(span *SpanPlan, dataset string, fields map[string]any, events []SpanEvent) (honeycombEvent, []honeycombEvent) {
+	service := dataset
+	if span.Service != "" {
+		service = span.Service
+	}
+	newEvent := func(ts time.Time) honeycombEvent {
+		return honeycombEvent{Time: ts, Data: map[string]any{
+			"service_name":   service,
+			"service.name":   service,
+			"trace.trace_id": span.TraceID.String(),
+		}}
+	}
+
+	ev := newEvent(span.Start)
+	ev.Data["name"] = span.Name
+	ev.Data["trace.span_id"] = span.SpanID.String()
+	if !span.IsRoot() {
+		ev.Data["trace.parent_id"] = span.ParentID.String()
+	}
+	if span.Kind != trace.SpanKindUnspecified {
+		ev.Data["span.kind"] = span.Kind.String()
+	}
+	for k, v := range fields {
+		ev.Data[k] = v
+	}
+	for k, v := range span.Attributes {
+		ev.Data[k] = v
+	}
+	if span.Error != nil {
+		for k, v := range span.Error.Fields() {
+			ev.Data[k] = v
+		}
+	}
+	ev.Data["duration_ms"] = float64(span.Duration()) / float64(time.Millisecond)
+
+	var annotations []honeycombEvent
+	for _, link := range span.Links {
+		lev := newEvent(span.Start)
+		lev.Data["trace.parent_id"] = span.SpanID.String()
+		lev.Data["trace.link.trace_id"] = link.TraceID.String()
+		lev.Data["trace.link.span_id"] = link.SpanID.String()
+		lev.Data["meta.annotation_type"] = "link"
+		annotations = append(annotations, lev)
+	}
+	for _, event := range events {
+		eev := newEvent(event.Time)
+		for k, v := range event.Fields {
+			eev.Data[k] = v
+		}
+		eev.Data["name"] = event.Name
+		eev.Data["trace.parent_id"] = span.SpanID.String()
+		eev.Data["meta.annotation_type"] = "span_event"
+		annotations = append(annotations, eev)
 	}
-	return ctx, root
+	return ev, annotations
 }
 
-func (t *SenderHoneycomb) CreateSpan(ctx context.Context, name string, level int, fielder *Fielder) (context.Context, Sendable) {
-	// a beeline span is already a Sendable
-	ctx, span := beeline.StartSpan(ctx, name)
-	for k, v := range fielder.GetFields(0, level) {
-		span.AddField(k, v)
+// newSpan creates the libhoney events for a span.
+func (t *SenderHoneycomb) newSpan(span *SpanPlan, fields map[string]any, events []SpanEvent) HoneycombSendable {
+	newEvent := func(e honeycombEvent) *libhoney.Event {
+		ev := t.builder.NewEvent()
+		ev.Timestamp = e.Time
+		ev.Add(e.Data)
+		return ev
 	}
-	return ctx, span
+	main, annotations := honeycombEvents(span, t.builder.Dataset, fields, events)
+	s := HoneycombSendable{ev: newEvent(main)}
+	for _, ann := range annotations {
+		s.annotations = append(s.annotations, newEvent(ann))
+	}
+	return s
+}
+
+func (t *SenderHoneycomb) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, t.newSpan(span, fielder.GetFields(count, 0), fielder.GetEvents(span))
+}
+
+func (t *SenderHoneycomb) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, t.newSpan(span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))
 }
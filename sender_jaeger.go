@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/jaegertracing/jaeger-idl/thrift-gen/jaeger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderJaeger)(nil)
+var _ DeliveryCounter = (*SenderJaeger)(nil)
+
+// jaegerSpan is a span in Jaeger's Thrift format, with the service whose
+// process it belongs to.
+type jaegerSpan struct {
+	service string
+	span    *jaeger.Span
+}
+
+// newJaegerSpan converts a planned span to Jaeger's format. Fields and
+// attributes become tags, and span events become logs. The kind and any error
+// are recorded the way the Jaeger clients record them: a span.kind tag, and an
+// error tag with an error log holding the details. Links become FOLLOWS_FROM
+// references.
+func newJaegerSpan(span *SpanPlan, fields map[string]any, events []SpanEvent) *jaeger.Span {
+	js := &jaeger.Span{
+		TraceIdHigh:   int64(binary.BigEndian.Uint64(span.TraceID[:8])),
+		TraceIdLow:    int64(binary.BigEndian.Uint64(span.TraceID[8:])),
+		SpanId:        int64(binary.BigEndian.Uint64(span.SpanID[:])),
+		OperationName: span.Name,
+		Flags:         1, // sampled
+		StartTime:     span.Start.UnixMicro(),
+		Duration:      span.Duration().Microseconds(),
+	}
+	if !span.IsRoot() {
+		js.ParentSpanId = int64(binary.BigEndian.Uint64(span.ParentID[:]))
+	}
+	tags := make(map[string]any, len(fields)+len(span.Attributes)+2)
+	for k, v := range fields {
+		tags[k] = v
+	}
+	for k, v := range span.Attributes {
+		tags[k] = v
+	}
+	if span.Kind != trace.SpanKindUnspecified {
+		tags["span.kind"] = span.Kind.String()
+	}
+	if e := span.Error; e != nil {
+		tags["error"] = true
+		js.Logs = append(js.Logs, &jaeger.Log{
+			Timestamp: span.End.UnixMicro(),
+			Fields: jaegerTags(map[string]any{
+				"event":      "error",
+				"error.kind": e.Type,
+				"message":    e.Message,
+				"stack":      e.Stacktrace,
+			}),
+		})
+	}
+	js.Tags = jaegerTags(tags)
+	for _, ev := range events {
+		fields := map[string]any{"event": ev.Name}
+		for k, v := range ev.Fields {
+			fields[k] = v
+		}
+		js.Logs = append(js.Logs, &jaeger.Log{Timestamp: ev.Time.UnixMicro(), Fields: jaegerTags(fields)})
+	}
+	sort.SliceStable(js.Logs, func(i, j int) bool { return js.Logs[i].Timestamp < js.Logs[j].Timestamp })
+	for _, link := range span.Links {
+		js.References = append(js.References, &jaeger.SpanRef{
+			RefType:     jaeger.SpanRefType_FOLLOWS_FROM,
+			TraceIdHigh: int64(binary.BigEndian.Uint64(link.TraceID[:8])),
+			TraceIdLow:  int64(binary.BigEndian.Uint64(link.TraceID[8:])),
+			SpanId:      int64(binary.BigEndian.Uint64(link.SpanID[:])),
+		})
+	}
+	return js
+}
+
+// jaegerTags converts a map of generated values to Jaeger tags, sorted by key.
+func jaegerTags(values map[string]any) []*jaeger.Tag {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]*jaeger.Tag, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, jaegerTag(attributeOf(k, values[k])))
+	}
+	return tags
+}
+
+func jaegerTag(kv attribute.KeyValue) *jaeger.Tag {
+	tag := &jaeger.Tag{Key: string(kv.Key)}
+	switch kv.Value.Type() {
+	case attribute.BOOL:
+		tag.VType = jaeger.TagType_BOOL
+		tag.VBool = thrift.BoolPtr(kv.Value.AsBool())
+	case attribute.INT64:
+		tag.VType = jaeger.TagType_LONG
+		tag.VLong = thrift.Int64Ptr(kv.Value.AsInt64())
+	case attribute.FLOAT64:
+		tag.VType = jaeger.TagType_DOUBLE
+		tag.VDouble = thrift.Float64Ptr(kv.Value.AsFloat64())
+	default:
+		tag.VType = jaeger.TagType_STRING
+		tag.VStr = thrift.StringPtr(kv.Value.Emit())
+	}
+	return tag
+}
+
+// SenderJaeger sends spans as Jaeger Thrift, posted to a Jaeger collector's
+// HTTP endpoint, which is /api/traces by default. Spans are queued and
+// batched like the other HTTP senders; each batch of spans is split by
+// service, since a Jaeger batch belongs to a single process, and each
+// service's spans are posted as a batch of their own. Resource attributes
+// become process tags.
+type SenderJaeger struct {
+	log       Logger
+	poster    *httpPoster
+	resources *ResourceGenerator
+	queue     *batchQueue[jaegerSpan]
+	spans     atomic.Int64
+	batches   atomic.Int64
+	failed    atomic.Int64
+}
+
+func NewSenderJaeger(log Logger, opts *Options) *SenderJaeger {
+	resources, err := NewResourceGenerator(opts.Global.Seed, opts.Output.ResourceAttrs)
+	if err != nil {
+		log.Fatal("unable to parse resource attributes: %v\n", err)
+	}
+	u := *opts.apihost
+	u.Path = opts.Output.JaegerPath
+	t := &SenderJaeger{
+		log:       log,
+		poster:    newHTTPPoster(log, opts, u.String(), nil, compression(opts, "jaeger")),
+		resources: resources,
+	}
+	t.queue = newBatchQueue(log, opts, t.send)
+	return t
+}
+
+// send posts a batch of spans, as one Jaeger batch for each service.
+func (t *SenderJaeger) send(spans []jaegerSpan) {
+	var batches []*jaeger.Batch
+	byService := make(map[string]*jaeger.Batch)
+	for _, js := range spans {
+		batch, ok := byService[js.service]
+		if !ok {
+			tags := make([]*jaeger.Tag, 0, len(t.resources.Attributes(js.service)))
+			for _, kv := range t.resources.Attributes(js.service) {
+				tags = append(tags, jaegerTag(kv))
+			}
+			batch = &jaeger.Batch{Process: &jaeger.Process{ServiceName: js.service, Tags: tags}}
+			byService[js.service] = batch
+			batches = append(batches, batch)
+		}
+		batch.Spans = append(batch.Spans, js.span)
+	}
+	serializer := thrift.NewTSerializer()
+	for _, batch := range batches {
+		t.post(serializer, batch)
+	}
+}
+
+// post posts a single Jaeger batch.
+func (t *SenderJaeger) post(serializer *thrift.TSerializer, batch *jaeger.Batch) {
+	n := int64(len(batch.Spans))
+	t.spans.Add(n)
+	t.batches.Add(1)
+	body, err := serializer.Write(context.Background(), batch)
+	if err != nil {
+		t.log.Error("unable to encode batch of %d spans: %v\n", n, err)
+		t.failed.Add(n)
+		return
+	}
+	resp, err := t.poster.post(body, "application/x-thrift")
+	if err != nil {
+		t.log.Error("batch of %d spans failed: %v\n", n, err)
+		t.failed.Add(n)
+		return
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		t.log.Error("batch of %d spans failed with %v\n", n, err)
+		t.failed.Add(n)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	t.log.Info("batch of %d spans for %s: status %d\n", n, batch.Process.ServiceName, resp.StatusCode)
+}
+
+func (t *SenderJaeger) newSendable(span *SpanPlan, fields map[string]any, events []SpanEvent) queuedSendable[jaegerSpan] {
+	return queuedSendable[jaegerSpan]{queue: t.queue, item: jaegerSpan{service: span.ServiceName(), span: newJaegerSpan(span, fields, events)}}
+}
+
+func (t *SenderJaeger) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(count, 0), fielder.GetEvents(span))
+}
+
+func (t *SenderJaeger) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, t.newSendable(span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))
+}
+
+// Close sends everything that's queued, then reports what happened to it.
+func (t *SenderJaeger) Close() {
+	t.queue.close()
+	t.log.Warn("sender sent %d spans in %d batches: %d failed, %d dropped\n",
+		t.spans.Load(), t.batches.Load(), t.failed.Load(), t.queue.dropped.Load())
+	t.poster.bytes.report(t.log)
+}
+
+// Delivered returns the number of spans posted successfully, and the number
+// that failed or were dropped.
+func (t *SenderJaeger) Delivered() (int64, int64) {
+	return t.spans.Load() - t.failed.Load(), t.failed.Load() + t.queue.dropped.Load()
+}
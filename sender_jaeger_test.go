@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/jaegertracing/jaeger-idl/thrift-gen/jaeger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_newJaegerSpan(t *testing.T) {
+	start := time.Now()
+	root := newRootPlan("checkout", start)
+	root.End = start.Add(20 * time.Millisecond)
+	child := root.addChild("GET /cart", start.Add(time.Millisecond))
+	child.End = start.Add(10 * time.Millisecond)
+	child.Kind = trace.SpanKindClient
+	child.Error = &SpanError{Type: "Error", Message: "ECONNRESET"}
+
+	js := newJaegerSpan(child, map[string]any{"count": int64(3), "ratio": 0.5}, []SpanEvent{{Name: "retry", Time: start.Add(2 * time.Millisecond)}})
+	if uint64(js.TraceIdHigh) != binary.BigEndian.Uint64(root.TraceID[:8]) || uint64(js.TraceIdLow) != binary.BigEndian.Uint64(root.TraceID[8:]) {
+		t.Errorf("trace ID doesn't match the plan")
+	}
+	if uint64(js.ParentSpanId) != binary.BigEndian.Uint64(root.SpanID[:]) || uint64(js.SpanId) != binary.BigEndian.Uint64(child.SpanID[:]) {
+		t.Errorf("span IDs don't match the plan")
+	}
+	if js.StartTime != child.Start.UnixMicro() || js.Duration != 9000 {
+		t.Errorf("unexpected start %d or duration %d", js.StartTime, js.Duration)
+	}
+	tags := make(map[string]*jaeger.Tag)
+	for _, tag := range js.Tags {
+		tags[tag.Key] = tag
+	}
+	if tag := tags["count"]; tag == nil || tag.VType != jaeger.TagType_LONG || *tag.VLong != 3 {
+		t.Errorf("unexpected count tag %v", tag)
+	}
+	if tag := tags["ratio"]; tag == nil || tag.VType != jaeger.TagType_DOUBLE {
+		t.Errorf("unexpected ratio tag %v", tag)
+	}
+	if tag := tags["span.kind"]; tag == nil || *tag.VStr != "client" {
+		t.Errorf("unexpected span.kind tag %v", tag)
+	}
+	if tag := tags["error"]; tag == nil || !*tag.VBool {
+		t.Errorf("unexpected error tag %v", tag)
+	}
+	// the retry happens before the error, which is logged when the span ends
+	if len(js.Logs) != 2 || *js.Logs[0].Fields[0].VStr != "retry" || js.Logs[1].Timestamp != child.End.UnixMicro() {
+		t.Errorf("unexpected logs %v", js.Logs)
+	}
+
+	jr := newJaegerSpan(root, nil, nil)
+	if jr.ParentSpanId != 0 || len(jr.Tags) != 0 || len(jr.Logs) != 0 {
+		t.Errorf("unexpected root span %v", jr)
+	}
+}
+
+func Test_SenderJaeger(t *testing.T) {
+	var mut sync.Mutex
+	received := make(map[string]int)
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/traces" || r.Header.Get("Content-Type") != "application/x-thrift" {
+			t.Errorf("unexpected path %s or content type %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		var batch jaeger.Batch
+		if err := thrift.NewTDeserializer().Read(context.Background(), &batch, body); err != nil {
+			t.Errorf("unable to decode batch: %v", err)
+		}
+		mut.Lock()
+		received[batch.Process.ServiceName] += len(batch.Spans)
+		mut.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	opts := &Options{}
+	opts.apihost = host
+	opts.Output.JaegerPath = "/api/traces"
+	opts.Output.Concurrency = 1
+	sender := NewSenderJaeger(NewLogger(0), opts)
+	root := sendSpans(t, sender, 1)
+
+	// the two spans are in different services, so they're in different batches
+	if received["root"] != 1 || received[root.Children[0].ServiceName()] != 1 || sender.batches.Load() != 2 || sender.failed.Load() != 0 {
+		t.Errorf("expected a batch with 1 span for each service, got %v with %d failed", received, sender.failed.Load())
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderMetrics)(nil)
+
+// A metricSpec is a metric whose values come from a field generator.
+type metricSpec struct {
+	name string
+	kind string // counter, histogram or gauge
+	gen  func() float64
+}
+
+// parseMetricSpecs parses metric specs, which map a metric name to KIND:SPEC,
+// where KIND is counter, histogram or gauge and SPEC is a constant or generator
+// that produces numbers, as for fields.
+func parseMetricSpecs(rng Rng, specs map[string]string) ([]*metricSpec, error) {
+	// parse them in a consistent order so they consume the rng consistently
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var metrics []*metricSpec
+	for _, name := range names {
+		kind, spec, ok := strings.Cut(specs[name], ":")
+		if !ok {
+			return nil, fmt.Errorf("metric %s must look like KIND:SPEC", name)
+		}
+		if kind != "counter" && kind != "histogram" && kind != "gauge" {
+			return nil, fmt.Errorf("metric %s has unknown kind %s; use counter, histogram or gauge", name, kind)
+		}
+		gens, err := parseUserFields(rng, map[string]string{name: spec})
+		if err != nil {
+			return nil, err
+		}
+		gen := gens[name]
+		if _, ok := numericValue(gen()); !ok {
+			return nil, fmt.Errorf("metric %s must use a numeric generator, not %s", name, spec)
+		}
+		metrics = append(metrics, &metricSpec{
+			name: name,
+			kind: kind,
+			gen: func() float64 {
+				v, _ := numericValue(gen())
+				return v
+			},
+		})
+	}
+	return metrics, nil
+}
+
+// numericValue converts a generated value to a float64, if it's a number.
+func numericValue(val any) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// SenderMetrics wraps another sender, and sends OTLP metrics alongside the
+// spans it sends. With --redmetrics, it records the rate, errors and duration
+// (RED) of every span as it's sent, by service and operation. Metrics given
+// with --metric record one generated value per trace, except for gauges, which
+// are observed each time the metrics are exported. Metrics are recorded at the
+// time they're sent, not at the time in the span.
+type SenderMetrics struct {
+	Sender
+	log      Logger
+	bytes    *byteCounter
+	red      bool
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+	mut      sync.Mutex // the generators share an rng
+	counters map[*metricSpec]metric.Float64Counter
+	histos   map[*metricSpec]metric.Float64Histogram
+	provider *sdkmetric.MeterProvider
+}
+
+func NewSenderMetrics(log Logger, opts *Options, sender Sender) *SenderMetrics {
+	specs, err := parseMetricSpecs(NewRng(opts.Global.Seed), opts.Output.MetricSpecs)
+	if err != nil {
+		log.Fatal("unable to parse metrics: %v\n", err)
+	}
+
+	bytes := &byteCounter{codec: compression(opts, "otel")}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(newOTelMetricReader(log, opts, bytes)),
+		sdkmetric.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(opts.Telemetry.Dataset))),
+	)
+	meter := provider.Meter(ResourceLibrary, metric.WithInstrumentationVersion(ResourceVersion))
+	m := &SenderMetrics{
+		Sender:   sender,
+		log:      WithPrefix(log, "metrics: "),
+		bytes:    bytes,
+		red:      opts.Output.REDMetrics,
+		counters: make(map[*metricSpec]metric.Float64Counter),
+		histos:   make(map[*metricSpec]metric.Float64Histogram),
+		provider: provider,
+	}
+
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.red {
+		m.requests, err = meter.Int64Counter("loadgen.requests", metric.WithUnit("{request}"),
+			metric.WithDescription("the number of spans sent"))
+		check(err)
+		m.errors, err = meter.Int64Counter("loadgen.errors", metric.WithUnit("{error}"),
+			metric.WithDescription("the number of spans sent that failed"))
+		check(err)
+		m.duration, err = meter.Float64Histogram("loadgen.duration", metric.WithUnit("ms"),
+			metric.WithDescription("the durations of the spans sent"))
+		check(err)
+	}
+	for _, spec := range specs {
+		switch spec.kind {
+		case "counter":
+			m.counters[spec], err = meter.Float64Counter(spec.name)
+		case "histogram":
+			m.histos[spec], err = meter.Float64Histogram(spec.name)
+		case "gauge":
+			_, err = meter.Float64ObservableGauge(spec.name,
+				metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+					m.mut.Lock()
+					defer m.mut.Unlock()
+					o.Observe(spec.gen())
+					return nil
+				}))
+		}
+		check(err)
+	}
+	if len(errs) > 0 {
+		log.Fatal("unable to create metrics: %v\n", errs)
+	}
+	return m
+}
+
+// metricsSendable records the span's metrics when it's sent.
+type metricsSendable struct {
+	Sendable
+	sender *SenderMetrics
+	span   *SpanPlan
+}
+
+func (s metricsSendable) Send() {
+	s.Sendable.Send()
+	s.sender.record(s.span)
+}
+
+// record records the RED metrics for a span.
+func (t *SenderMetrics) record(span *SpanPlan) {
+	if !t.red {
+		return
+	}
+	ctx := context.Background()
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(span.ServiceName()),
+		attribute.String("operation", span.Name),
+	}
+	if span.Kind != trace.SpanKindUnspecified {
+		attrs = append(attrs, attribute.String("span.kind", span.Kind.String()))
+	}
+	set := metric.WithAttributes(attrs...)
+	t.requests.Add(ctx, 1, set)
+	if span.Error != nil {
+		t.errors.Add(ctx, 1, set)
+	}
+	t.duration.Record(ctx, float64(span.Duration())/float64(time.Millisecond), set)
+}
+
+func (t *SenderMetrics) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	if len(t.counters) > 0 || len(t.histos) > 0 {
+		t.mut.Lock()
+		for spec, counter := range t.counters {
+			// counters can't go down
+			counter.Add(ctx, max(0, spec.gen()))
+		}
+		for spec, histo := range t.histos {
+			histo.Record(ctx, spec.gen())
+		}
+		t.mut.Unlock()
+	}
+	ctx, s := t.Sender.CreateTrace(ctx, span, fielder, count)
+	return ctx, metricsSendable{Sendable: s, sender: t, span: span}
+}
+
+func (t *SenderMetrics) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	ctx, s := t.Sender.CreateSpan(ctx, span, fielder)
+	return ctx, metricsSendable{Sendable: s, sender: t, span: span}
+}
+
+// Delivered returns the wrapped sender's counts, if it has them.
+func (t *SenderMetrics) Delivered() (int64, int64) {
+	if dc, ok := t.Sender.(DeliveryCounter); ok {
+		return dc.Delivered()
+	}
+	return 0, 0
+}
+
+func (t *SenderMetrics) Close() {
+	t.Sender.Close()
+	_ = t.provider.Shutdown(context.Background())
+	t.bytes.report(t.log)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func Test_parseMetricSpecs(t *testing.T) {
+	metrics, err := parseMetricSpecs(NewRng("hello"), map[string]string{
+		"queue.depth":  "gauge:/ig50,10",
+		"bytes.sent":   "counter:/i1000",
+		"request.size": "histogram:512",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]string{}
+	for _, m := range metrics {
+		kinds[m.name] = m.kind
+	}
+	if kinds["queue.depth"] != "gauge" || kinds["bytes.sent"] != "counter" || kinds["request.size"] != "histogram" {
+		t.Errorf("unexpected kinds %v", kinds)
+	}
+	for _, m := range metrics {
+		if m.name == "request.size" && m.gen() != 512 {
+			t.Errorf("expected constant 512, got %v", m.gen())
+		}
+	}
+
+	for _, bad := range []string{"/i100", "meter:/i100", "gauge:/sx8", "counter:hello"} {
+		if _, err := parseMetricSpecs(NewRng("hello"), map[string]string{"m": bad}); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
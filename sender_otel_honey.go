@@ -2,38 +2,135 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
-	"math/rand"
 	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/encoding/gzip"
 )
 
 // make sure it implements Sender
 var _ Sender = (*SenderOTel)(nil)
+var _ DeliveryCounter = (*SenderOTel)(nil)
 
+// OTelSendable is a started span, with the events that happen during it. The
+// events are added when the span is sent, as span events, log records, or both.
 type OTelSendable struct {
 	trace.Span
+	end        time.Time
+	events     []SpanEvent
+	spanEvents bool
+	logger     otellog.Logger
 }
 
 func (s OTelSendable) Send() {
-	(trace.Span)(s).End()
+	for _, ev := range s.events {
+		if s.spanEvents {
+			attrs := make([]attribute.KeyValue, 0, len(ev.Fields))
+			for k, v := range ev.Fields {
+				attrs = append(attrs, attributeOf(k, v))
+			}
+			s.Span.AddEvent(ev.Name, trace.WithTimestamp(ev.Time), trace.WithAttributes(attrs...))
+		}
+		if s.logger != nil {
+			emitLogRecord(context.Background(), s.logger, s.Span, ev)
+		}
+	}
+	s.Span.End(trace.WithTimestamp(s.end))
+}
+
+type planKey struct{}
+
+// planIDGenerator makes the OTel SDK use the trace and span IDs from the
+// SpanPlan stored in the context passed to Start, instead of random ones.
+type planIDGenerator struct{}
+
+func (planIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if span, ok := ctx.Value(planKey{}).(*SpanPlan); ok {
+		return span.TraceID, span.SpanID
+	}
+	return newTraceID(), newSpanID()
+}
+
+func (planIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if span, ok := ctx.Value(planKey{}).(*SpanPlan); ok {
+		return span.SpanID
+	}
+	return newSpanID()
+}
+
+// countingExporter counts the spans that its exporter exports, and the ones
+// that it fails to, so that the sender can report them.
+type countingExporter struct {
+	sdktrace.SpanExporter
+	exported atomic.Int64
+	failed   atomic.Int64
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		e.failed.Add(int64(len(spans)))
+	} else {
+		e.exported.Add(int64(len(spans)))
+	}
+	return err
+}
+
+// countingProcessor counts the spans that end. The batch span processor drops
+// spans when its queue is full without saying so, so once it has shut down,
+// any span that ended but was neither exported nor failed was dropped.
+type countingProcessor struct {
+	sdktrace.SpanProcessor
+	ended atomic.Int64
 }
 
+func (p *countingProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	p.ended.Add(1)
+	p.SpanProcessor.OnEnd(s)
+}
+
+// SenderOTel sends spans through the OTel SDK. Every tracer provider shares one
+// batch span processor, so that spans from all of them are batched together, but
+// each has its own resource. Normally there is a single provider whose
+// service.name is the dataset; with --resources=service, each service gets its
+// own provider and resource, created the first time the service is seen. When
+// span events are also sent as log records, each resource gets a logger
+// provider too, sharing one batch log processor in the same way.
 type SenderOTel struct {
-	tracer   trace.Tracer
-	shutdown func()
+	log        Logger
+	dataset    string
+	perService bool
+	bsp        *countingProcessor
+	blp        sdklog.Processor
+	spanEvents bool
+	resources  *ResourceGenerator
+	mut        sync.RWMutex
+	services   map[string]*otelService
+	exporter   *countingExporter
+	bytes      *byteCounter
+	shutdown   func()
+}
+
+// otelService holds the tracer and logger for one resource.
+type otelService struct {
+	tracer trace.Tracer
+	logger otellog.Logger
 }
 
 func otelTracesFromURL(u *url.URL) string {
@@ -54,23 +151,26 @@ func (l OtelLogger) Fatalf(format string, args ...interface{}) {
 }
 
 func NewSenderOTel(log Logger, opts *Options) *SenderOTel {
+	// spans, and span events sent as log records, are counted together
+	bytes := &byteCounter{codec: compression(opts, "otel")}
 	var client otlptrace.Client
 	switch opts.Output.Protocol {
 	case "grpc":
-		client = setupOTelGRPCClient(opts)
+		client = setupOTelGRPCClient(opts, bytes)
 	case "http":
-		client = setupOTelHTTPClient(opts)
+		client = setupOTelHTTPClient(opts, bytes)
 	default:
 		log.Fatal("unknown protocol: %s", opts.Output.Protocol)
 	}
 
-	exporter, err := otlptrace.New(
+	otlpExporter, err := otlptrace.New(
 		context.Background(),
 		client,
 	)
 	if err != nil {
 		log.Fatal("failure configuring otel trace exporter: %v", err)
 	}
+	exporter := &countingExporter{SpanExporter: otlpExporter}
 
 	var bspOpts []sdktrace.BatchSpanProcessorOption
 	if opts.Output.BatchTimeout != 0 {
@@ -86,81 +186,223 @@ func NewSenderOTel(log Logger, opts *Options) *SenderOTel {
 	if opts.Output.ExportTimeout != 0 {
 		bspOpts = append(bspOpts, sdktrace.WithExportTimeout(opts.Output.ExportTimeout))
 	}
+	if opts.Quantity.Backfill > 0 {
+		// when backfilling we want to go as fast as the exporter can, not drop spans
+		bspOpts = append(bspOpts, sdktrace.WithBlocking())
+	}
 
-	bsp := sdktrace.NewBatchSpanProcessor(exporter, bspOpts...)
-	otel.SetTracerProvider(sdktrace.NewTracerProvider(
-		sdktrace.WithSpanProcessor(bsp),
-		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.Telemetry.Dataset))),
-	))
+	resources, err := NewResourceGenerator(opts.Global.Seed, opts.Output.ResourceAttrs)
+	if err != nil {
+		log.Fatal("unable to parse resource attributes: %v\n", err)
+	}
+
+	bsp := &countingProcessor{SpanProcessor: sdktrace.NewBatchSpanProcessor(exporter, bspOpts...)}
+	var blp sdklog.Processor
+	if opts.Format.Events > 0 && opts.Output.EventsAs != "span" {
+		blp, _ = newOTelLogProcessor(log, opts, bytes)
+	}
 	otelshutdown := func() {
 		_ = bsp.Shutdown(context.Background())
 		_ = exporter.Shutdown(context.Background())
+		if blp != nil {
+			// the processor flushes and shuts down its exporter
+			if err := blp.Shutdown(context.Background()); err != nil {
+				log.Error("unable to shut down log processor: %v\n", err)
+			}
+		}
+		exported, failed := exporter.exported.Load(), exporter.failed.Load()
+		log.Warn("sender exported %d spans: %d failed, %d dropped\n", exported, failed, bsp.ended.Load()-exported-failed)
+		bytes.report(log)
 	}
 
 	return &SenderOTel{
-		tracer:   otel.Tracer(ResourceLibrary, trace.WithInstrumentationVersion(ResourceVersion)),
-		shutdown: otelshutdown,
+		log:        log,
+		dataset:    opts.Telemetry.Dataset,
+		perService: opts.Output.Resources == "service",
+		bsp:        bsp,
+		blp:        blp,
+		spanEvents: opts.Output.EventsAs != "log",
+		resources:  resources,
+		services:   make(map[string]*otelService),
+		exporter:   exporter,
+		bytes:      bytes,
+		shutdown:   otelshutdown,
 	}
 }
 
+// service returns the tracer and logger for the given service, creating
+// providers with their own resource if this is the first time we've seen it.
+func (t *SenderOTel) service(name string) *otelService {
+	t.mut.RLock()
+	svc, ok := t.services[name]
+	t.mut.RUnlock()
+	if ok {
+		return svc
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if svc, ok := t.services[name]; ok {
+		return svc
+	}
+	attrs := append([]attribute.KeyValue{semconv.ServiceNameKey.String(name)}, t.resources.Attributes(name)...)
+	res := resource.NewWithAttributes(semconv.SchemaURL, attrs...)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(t.bsp),
+		sdktrace.WithIDGenerator(planIDGenerator{}),
+		sdktrace.WithResource(res),
+	)
+	svc = &otelService{
+		tracer: provider.Tracer(ResourceLibrary, trace.WithInstrumentationVersion(ResourceVersion)),
+	}
+	if t.blp != nil {
+		logProvider := sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(t.blp),
+			sdklog.WithResource(res),
+		)
+		svc.logger = logProvider.Logger(ResourceLibrary, otellog.WithInstrumentationVersion(ResourceVersion))
+	}
+	t.services[name] = svc
+	t.log.Debug("created tracer provider for service %s\n", name)
+	return svc
+}
+
 func (t *SenderOTel) Close() {
 	t.shutdown()
 }
 
-func (t *SenderOTel) CreateTrace(ctx context.Context, name string, fielder *Fielder, count int64) (context.Context, Sendable) {
-	ctx, root := t.tracer.Start(ctx, name)
-	fielder.AddFields(root, count, 0)
-	var ots OTelSendable
-	ots.Span = root
-	return ctx, ots
+// Delivered returns the number of spans exported, and the number that failed
+// or were dropped.
+func (t *SenderOTel) Delivered() (int64, int64) {
+	exported := t.exporter.exported.Load()
+	return exported, t.bsp.ended.Load() - exported
 }
 
-func (t *SenderOTel) CreateSpan(ctx context.Context, name string, level int, fielder *Fielder) (context.Context, Sendable) {
-	ctx, span := t.tracer.Start(ctx, name)
-	if rand.Intn(10) == 0 {
-		span.AddEvent("exception", trace.WithAttributes(
-			attribute.KeyValue{Key: "exception.type", Value: attribute.StringValue("error")},
-			attribute.KeyValue{Key: "exception.message", Value: attribute.StringValue("error message")},
-			attribute.KeyValue{Key: "exception.stacktrace", Value: attribute.StringValue("stacktrace")},
-			attribute.KeyValue{Key: "exception.escaped", Value: attribute.BoolValue(false)},
+// start starts an OTel span with the name, IDs, kind, attributes, links and
+// start time from the plan, and records its error, if any. The span's events
+// are generated now, but only added when it's sent.
+func (t *SenderOTel) start(ctx context.Context, plan *SpanPlan, fielder *Fielder) (context.Context, OTelSendable) {
+	ctx = context.WithValue(ctx, planKey{}, plan)
+	opts := []trace.SpanStartOption{trace.WithTimestamp(plan.Start)}
+	if plan.Kind != trace.SpanKindUnspecified {
+		opts = append(opts, trace.WithSpanKind(plan.Kind))
+	}
+	for _, link := range plan.Links {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    link.TraceID,
+			SpanID:     link.SpanID,
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		})}))
+	}
+	if len(plan.Attributes) > 0 {
+		attrs := make([]attribute.KeyValue, 0, len(plan.Attributes))
+		for k, v := range plan.Attributes {
+			attrs = append(attrs, attributeOf(k, v))
+		}
+		opts = append(opts, trace.WithAttributes(attrs...))
+	}
+	service := t.dataset
+	if t.perService {
+		service = plan.ServiceName()
+	} else if plan.Service != "" {
+		// all spans share the dataset's resource, so record the service on the span
+		opts = append(opts, trace.WithAttributes(semconv.ServiceNameKey.String(plan.Service)))
+	}
+	svc := t.service(service)
+	ctx, span := svc.tracer.Start(ctx, plan.Name, opts...)
+	if e := plan.Error; e != nil {
+		span.SetStatus(codes.Error, e.Message)
+		span.AddEvent("exception", trace.WithTimestamp(plan.End), trace.WithAttributes(
+			semconv.ExceptionTypeKey.String(e.Type),
+			semconv.ExceptionMessageKey.String(e.Message),
+			semconv.ExceptionStacktraceKey.String(e.Stacktrace),
+			semconv.ExceptionEscapedKey.Bool(true),
 		))
 	}
-	fielder.AddFields(span, 0, level)
-	var ots OTelSendable
-	ots.Span = span
-	return ctx, ots
+	return ctx, OTelSendable{
+		Span:       span,
+		end:        plan.End,
+		events:     fielder.GetEvents(plan),
+		spanEvents: t.spanEvents,
+		logger:     svc.logger,
+	}
+}
+
+func (t *SenderOTel) CreateTrace(ctx context.Context, plan *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	ctx, root := t.start(ctx, plan, fielder)
+	fielder.AddFields(root.Span, count, 0)
+	return ctx, root
 }
 
-func setupOTelHTTPClient(opts *Options) otlptrace.Client {
+func (t *SenderOTel) CreateSpan(ctx context.Context, plan *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	ctx, span := t.start(ctx, plan, fielder)
+	fielder.AddFields(span.Span, 0, plan.Level)
+	return ctx, span
+}
+
+// otlpHeaders returns the headers sent with every OTLP request: the API key, if
+// there is one and the host is Honeycomb's, and any others given with --header,
+// --bearertoken or --basicauth. The API key can come from the environment
+// without being asked for, so it's never sent anywhere else unless it's given
+// with --header.
+func otlpHeaders(opts *Options) map[string]string {
+	headers := make(map[string]string)
+	if opts.Telemetry.APIKey != "" && isHoneycombHost(opts.apihost) {
+		headers["x-honeycomb-team"] = opts.Telemetry.APIKey
+	}
+	for k, v := range opts.Telemetry.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// isHoneycombHost returns whether a host is one of Honeycomb's.
+func isHoneycombHost(u *url.URL) bool {
+	host := u.Hostname()
+	return host == "honeycomb.io" || strings.HasSuffix(host, ".honeycomb.io")
+}
+
+// otlpPath returns the URL path that OTLP/HTTP requests for a signal (traces,
+// logs or metrics) are posted to: the host's path followed by /v1/ and the
+// signal, unless --otlppath gives the path for the sender's own signal.
+func otlpPath(opts *Options, signal string) string {
+	own := "traces"
+	if opts.Output.Sender == "otellogs" {
+		own = "logs"
+	}
+	if signal == own && opts.Output.OTLPPath != "" {
+		return opts.Output.OTLPPath
+	}
+	return path.Join("/", opts.apihost.Path, "v1", signal)
+}
+
+func setupOTelHTTPClient(opts *Options, bytes *byteCounter) otlptrace.Client {
 	options := []otlptracehttp.Option{
 		otlptracehttp.WithEndpoint(opts.apihost.Host),
-		otlptracehttp.WithHeaders(map[string]string{
-			"x-honeycomb-team": opts.Telemetry.APIKey,
-		}),
-		otlptracehttp.WithCompression(otlptracehttp.GzipCompression),
+		otlptracehttp.WithURLPath(otlpPath(opts, "traces")),
+		otlptracehttp.WithHeaders(otlpHeaders(opts)),
+		// the client compresses requests itself, so that it can count their bytes
+		otlptracehttp.WithHTTPClient(otlpHTTPClient(opts, bytes)),
 	}
 	if opts.Telemetry.Insecure {
 		options = append(options, otlptracehttp.WithInsecure())
-	} else {
-		options = append(options, otlptracehttp.WithTLSClientConfig(&tls.Config{}))
 	}
 	return otlptracehttp.NewClient(
 		options...,
 	)
 }
 
-func setupOTelGRPCClient(opts *Options) otlptrace.Client {
+func setupOTelGRPCClient(opts *Options, bytes *byteCounter) otlptrace.Client {
 	options := []otlptracegrpc.Option{
 		otlptracegrpc.WithEndpoint(opts.apihost.Host),
-		otlptracegrpc.WithHeaders(map[string]string{
-			"x-honeycomb-team": opts.Telemetry.APIKey,
-		}),
-		otlptracegrpc.WithCompressor(gzip.Name),
+		otlptracegrpc.WithHeaders(otlpHeaders(opts)),
+		otlptracegrpc.WithDialOption(grpcDialOptions(bytes)...),
 	}
 	if opts.Telemetry.Insecure {
 		options = append(options, otlptracegrpc.WithInsecure())
 	} else {
-		options = append(options, otlptracegrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		options = append(options, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig(opts))))
 	}
 	return otlptracegrpc.NewClient(
 		options...,
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"pgregory.net/rand"
+)
+
+// the body of log records, if none is given
+const defaultLogBody = "request handled in {/i1,500}ms|cache miss for key {/sx8}|retrying request to {/sw8}|user {/sw20} logged in|connection reset by peer"
+
+// the severities that can be given with --logseverity
+var severities = map[string]otellog.Severity{
+	"trace": otellog.SeverityTrace,
+	"debug": otellog.SeverityDebug,
+	"info":  otellog.SeverityInfo,
+	"warn":  otellog.SeverityWarn,
+	"error": otellog.SeverityError,
+	"fatal": otellog.SeverityFatal,
+}
+
+// make sure it implements Sender
+var _ Sender = (*SenderOTelLogs)(nil)
+var _ DeliveryCounter = (*SenderOTelLogs)(nil)
+
+// SenderOTelLogs sends each planned span as an OTLP log record instead of a
+// span, so that the trace generator and its rate control can load a log
+// pipeline. Records have the span's fields as attributes, a body from a
+// template, and a random severity, except that the records for failed spans
+// are always errors.
+type SenderOTelLogs struct {
+	log        Logger
+	dataset    string
+	perService bool
+	blp        sdklog.Processor
+	resources  *ResourceGenerator
+	severity   *severityPicker
+	bodyMut    sync.Mutex // the body generator's rng isn't safe to share
+	body       func() string
+	mut        sync.RWMutex
+	loggers    map[string]otellog.Logger
+	records    atomic.Int64
+	exporter   *countingLogExporter
+	bytes      *byteCounter
+	shutdown   func()
+}
+
+// severityPicker chooses severities at random according to their weights.
+type severityPicker struct {
+	severities []otellog.Severity
+	names      []string
+	weights    []float64
+	total      float64
+}
+
+// newSeverityPicker takes a map of severity names to weights. With no
+// weights, every record is INFO.
+func newSeverityPicker(weights map[string]float64) (*severityPicker, error) {
+	if len(weights) == 0 {
+		weights = map[string]float64{"info": 1}
+	}
+	// sort them so that the same weights always pick the same way
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p := &severityPicker{}
+	for _, name := range names {
+		sev, ok := severities[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown severity %s; use trace, debug, info, warn, error or fatal", name)
+		}
+		if weights[name] < 0 {
+			return nil, fmt.Errorf("weight for severity %s must not be negative", name)
+		}
+		p.severities = append(p.severities, sev)
+		p.names = append(p.names, strings.ToUpper(name))
+		p.weights = append(p.weights, weights[name])
+		p.total += weights[name]
+	}
+	if p.total == 0 {
+		return nil, fmt.Errorf("at least one severity needs a positive weight")
+	}
+	return p, nil
+}
+
+// pick returns a severity and its name.
+func (p *severityPicker) pick() (otellog.Severity, string) {
+	r := rand.Float64() * p.total
+	for i, w := range p.weights {
+		if r < w {
+			return p.severities[i], p.names[i]
+		}
+		r -= w
+	}
+	last := len(p.weights) - 1
+	return p.severities[last], p.names[last]
+}
+
+func NewSenderOTelLogs(log Logger, opts *Options) *SenderOTelLogs {
+	severity, err := newSeverityPicker(opts.Output.LogSeverity)
+	if err != nil {
+		log.Fatal("unable to parse log severities: %v\n", err)
+	}
+	spec := opts.Output.LogBody
+	if spec == "" {
+		spec = defaultLogBody
+	}
+	body, err := parseNameTemplate(NewRng(opts.Global.Seed), spec)
+	if err != nil {
+		log.Fatal("unable to parse log body: %v\n", err)
+	}
+	resources, err := NewResourceGenerator(opts.Global.Seed, opts.Output.ResourceAttrs)
+	if err != nil {
+		log.Fatal("unable to parse resource attributes: %v\n", err)
+	}
+
+	bytes := &byteCounter{codec: compression(opts, "otellogs")}
+	blp, exporter := newOTelLogProcessor(log, opts, bytes)
+	return &SenderOTelLogs{
+		log:        log,
+		dataset:    opts.Telemetry.Dataset,
+		perService: opts.Output.Resources == "service",
+		blp:        blp,
+		resources:  resources,
+		severity:   severity,
+		body:       body,
+		loggers:    make(map[string]otellog.Logger),
+		exporter:   exporter,
+		bytes:      bytes,
+		shutdown: func() {
+			// the processor flushes and shuts down its exporter
+			if err := blp.Shutdown(context.Background()); err != nil {
+				log.Error("unable to shut down log processor: %v\n", err)
+			}
+		},
+	}
+}
+
+// logger returns the logger for the given service, creating a logger provider
+// with its own resource if this is the first time we've seen it.
+func (t *SenderOTelLogs) logger(service string) otellog.Logger {
+	t.mut.RLock()
+	logger, ok := t.loggers[service]
+	t.mut.RUnlock()
+	if ok {
+		return logger
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if logger, ok := t.loggers[service]; ok {
+		return logger
+	}
+	attrs := append([]attribute.KeyValue{semconv.ServiceNameKey.String(service)}, t.resources.Attributes(service)...)
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(t.blp),
+		sdklog.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+	)
+	logger = provider.Logger(ResourceLibrary, otellog.WithInstrumentationVersion(ResourceVersion))
+	t.loggers[service] = logger
+	t.log.Debug("created logger provider for service %s\n", service)
+	return logger
+}
+
+// OTelLogSendable is a log record waiting to be emitted when its span ends.
+type OTelLogSendable struct {
+	logger otellog.Logger
+	rec    otellog.Record
+}
+
+func (s OTelLogSendable) Send() {
+	s.logger.Emit(context.Background(), s.rec)
+}
+
+// newRecord makes the log record for a span.
+func (t *SenderOTelLogs) newRecord(span *SpanPlan, fields map[string]any) OTelLogSendable {
+	var rec otellog.Record
+	rec.SetTimestamp(span.End)
+	rec.SetObservedTimestamp(time.Now())
+	t.bodyMut.Lock()
+	rec.SetBody(otellog.StringValue(t.body()))
+	t.bodyMut.Unlock()
+	if e := span.Error; e != nil {
+		rec.SetSeverity(otellog.SeverityError)
+		rec.SetSeverityText("ERROR")
+		rec.AddAttributes(
+			otellog.String(string(semconv.ExceptionTypeKey), e.Type),
+			otellog.String(string(semconv.ExceptionMessageKey), e.Message),
+			otellog.String(string(semconv.ExceptionStacktraceKey), e.Stacktrace),
+		)
+	} else {
+		sev, name := t.severity.pick()
+		rec.SetSeverity(sev)
+		rec.SetSeverityText(name)
+	}
+	for k, v := range fields {
+		rec.AddAttributes(logAttributeOf(k, v))
+	}
+	for k, v := range span.Attributes {
+		rec.AddAttributes(logAttributeOf(k, v))
+	}
+
+	service := t.dataset
+	if t.perService {
+		service = span.ServiceName()
+	} else if span.Service != "" {
+		// all records share the dataset's resource, so record the service on each
+		rec.AddAttributes(otellog.String(string(semconv.ServiceNameKey), span.Service))
+	}
+	t.records.Add(1)
+	return OTelLogSendable{logger: t.logger(service), rec: rec}
+}
+
+func (t *SenderOTelLogs) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, t.newRecord(span, fielder.GetFields(count, 0))
+}
+
+func (t *SenderOTelLogs) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, t.newRecord(span, fielder.GetFields(0, span.Level))
+}
+
+func (t *SenderOTelLogs) Close() {
+	t.shutdown()
+	exported, failed := t.exporter.exported.Load(), t.exporter.failed.Load()
+	t.log.Warn("sender sent %d log records: %d exported, %d failed, %d dropped\n",
+		t.records.Load(), exported, failed, t.records.Load()-exported-failed)
+	t.bytes.report(t.log)
+}
+
+// Delivered returns the number of log records exported, and the number that
+// failed or were dropped because the batch processor's queue was full.
+func (t *SenderOTelLogs) Delivered() (int64, int64) {
+	exported := t.exporter.exported.Load()
+	return exported, t.records.Load() - exported
+}
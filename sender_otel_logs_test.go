@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+func Test_severityPicker(t *testing.T) {
+	p, err := newSeverityPicker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sev, name := p.pick(); sev != otellog.SeverityInfo || name != "INFO" {
+		t.Errorf("expected INFO by default, got %v %s", sev, name)
+	}
+
+	p, err = newSeverityPicker(map[string]float64{"warn": 1, "Error": 3, "debug": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		_, name := p.pick()
+		counts[name]++
+	}
+	if counts["DEBUG"] != 0 {
+		t.Errorf("debug has no weight but was picked %d times", counts["DEBUG"])
+	}
+	if counts["ERROR"] < 2700 || counts["ERROR"] > 3300 {
+		t.Errorf("expected about 3000 errors, got %d", counts["ERROR"])
+	}
+
+	for _, bad := range []map[string]float64{{"loud": 1}, {"info": -1}, {"info": 0}} {
+		if _, err := newSeverityPicker(bad); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
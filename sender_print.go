@@ -3,56 +3,57 @@ package main
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // make sure it implements Sender
 var _ Sender = (*SenderPrint)(nil)
+var _ DeliveryCounter = (*SenderPrint)(nil)
 
 func ft(ts time.Time) string {
 	return ts.Format("15:04:05.000")
 }
 
-// randID creates a random byte array of length l and returns it as a hex string.
-func randID(l int) string {
-	id := make([]byte, l)
-	for i := 0; i < l; i++ {
-		id[i] = byte(rand.Intn(256))
-	}
-	return fmt.Sprintf("%x", id)
-}
-
-type traceInfo struct {
-	TraceId  string
-	SpanId   string
-	ParentId string
-}
-
-func (t *traceInfo) span(parent string) *traceInfo {
-	return &traceInfo{
-		TraceId:  t.TraceId,
-		SpanId:   randID(4),
-		ParentId: parent,
-	}
-}
-
 type PrintSendable struct {
-	TInfo     *traceInfo
-	Name      string
-	StartTime time.Time
-	Fields    map[string]interface{}
-	log       Logger
+	Span   *SpanPlan
+	Fields map[string]interface{}
+	Events []SpanEvent
+	log    Logger
 }
 
 func (s *PrintSendable) Send() {
-	endTime := time.Now()
-	s.log.Printf("%s - T:%6.6s S:%4.4s P%4.4s start:%v end:%v %v\n", s.Name, s.TInfo.TraceId, s.TInfo.SpanId, s.TInfo.ParentId, ft(s.StartTime), ft(endTime), s.Fields)
+	parentID := ""
+	if !s.Span.IsRoot() {
+		parentID = s.Span.ParentID.String()
+	}
+	name := s.Span.Name
+	if s.Span.Service != "" {
+		name = s.Span.Service + " " + name
+	}
+	if s.Span.Kind != trace.SpanKindUnspecified {
+		name += " (" + s.Span.Kind.String() + ")"
+	}
+	for k, v := range s.Span.Attributes {
+		s.Fields[k] = v
+	}
+	for _, link := range s.Span.Links {
+		name += fmt.Sprintf(" L:%6.6s/%4.4s", link.TraceID, link.SpanID)
+	}
+	if s.Span.Error != nil {
+		name += " ERROR(" + s.Span.Error.Message + ")"
+	}
+	for _, ev := range s.Events {
+		name += fmt.Sprintf(" E:%s@%s", ev.Name, ft(ev.Time))
+	}
+	s.log.Printf("%s - T:%6.6s S:%4.4s P%4.4s start:%v end:%v %v\n", name, s.Span.TraceID, s.Span.SpanID, parentID, ft(s.Span.Start), ft(s.Span.End), s.Fields)
 }
 
 type SenderPrint struct {
-	tracecount int
-	nspans     int
+	tracecount atomic.Int64
+	nspans     atomic.Int64
 	log        Logger
 }
 
@@ -63,38 +64,31 @@ func NewSenderPrint(log Logger, opts *Options) Sender {
 }
 
 func (t *SenderPrint) Close() {
-	t.log.Warn("sender sent %d traces with %d spans\n", t.tracecount, t.nspans)
+	t.log.Warn("sender sent %d traces with %d spans\n", t.tracecount.Load(), t.nspans.Load())
 }
 
-type PrintKey string
+// Delivered returns the number of spans created, since none of them can fail.
+func (t *SenderPrint) Delivered() (int64, int64) {
+	return t.nspans.Load(), 0
+}
 
-func (t *SenderPrint) CreateTrace(ctx context.Context, name string, fielder *Fielder, count int64) (context.Context, Sendable) {
-	t.tracecount++
-	t.nspans++
-	tinfo := &traceInfo{
-		TraceId:  randID(6),
-		SpanId:   randID(4),
-		ParentId: "",
-	}
-	ctx = context.WithValue(ctx, PrintKey("trace"), tinfo)
+func (t *SenderPrint) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	t.tracecount.Add(1)
+	t.nspans.Add(1)
 	return ctx, &PrintSendable{
-		Name:      name,
-		TInfo:     tinfo,
-		StartTime: time.Now(),
-		Fields:    fielder.GetFields(count, 0),
-		log:       t.log,
+		Span:   span,
+		Fields: fielder.GetFields(count, 0),
+		Events: fielder.GetEvents(span),
+		log:    t.log,
 	}
 }
 
-func (t *SenderPrint) CreateSpan(ctx context.Context, name string, level int, fielder *Fielder) (context.Context, Sendable) {
-	t.nspans++
-	tinfo := ctx.Value(PrintKey("trace")).(*traceInfo)
-	ctx = context.WithValue(ctx, PrintKey("trace"), tinfo.span(tinfo.SpanId))
+func (t *SenderPrint) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	t.nspans.Add(1)
 	return ctx, &PrintSendable{
-		Name:      name,
-		TInfo:     tinfo.span(tinfo.SpanId),
-		StartTime: time.Now(),
-		Fields:    fielder.GetFields(0, level),
-		log:       t.log,
+		Span:   span,
+		Fields: fielder.GetFields(0, span.Level),
+		Events: fielder.GetEvents(span),
+		log:    t.log,
 	}
 }
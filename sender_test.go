@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestServer starts an HTTP server that's closed when the test ends, and
+// returns its URL as --host would give it.
+func newTestServer(t *testing.T, handler http.HandlerFunc) *url.URL {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return parseHost(NewLogger(0), server.URL, true)
+}
+
+// sendSpans sends a trace with a root span and the given number of child
+// spans, each in a service of its own, and closes the sender. It returns the
+// root span.
+func sendSpans(t *testing.T, sender Sender, children int) *SpanPlan {
+	t.Helper()
+	f, err := NewFielder("hello", nil, nil, 20, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	root := newRootPlan("root", start)
+	root.End = start.Add(10 * time.Millisecond)
+	for i := range children {
+		child := root.addChild(f.GetServiceName(i), start)
+		child.End = root.End
+	}
+	ctx, s := sender.CreateTrace(context.Background(), root, f, 1)
+	s.Send()
+	for _, child := range root.Children {
+		_, s := sender.CreateSpan(ctx, child, f)
+		s.Send()
+	}
+	sender.Close()
+	return root
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// make sure it implements Sender
+var _ Sender = (*SenderZipkin)(nil)
+var _ DeliveryCounter = (*SenderZipkin)(nil)
+
+// zipkinSpan is a span in Zipkin's v2 JSON format.
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind,omitempty"`
+	Timestamp      int64              `json:"timestamp"` // microseconds since the epoch
+	Duration       int64              `json:"duration"`  // microseconds
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// Zipkin only has these kinds; other spans leave the kind out
+var zipkinKinds = map[trace.SpanKind]string{
+	trace.SpanKindClient:   "CLIENT",
+	trace.SpanKindServer:   "SERVER",
+	trace.SpanKindProducer: "PRODUCER",
+	trace.SpanKindConsumer: "CONSUMER",
+}
+
+// newZipkinSpan converts a planned span to Zipkin's format. Fields, attributes
+// and errors become tags, since Zipkin tags are all strings, and span events
+// become annotations. Zipkin has no links, so they're left out.
+func newZipkinSpan(span *SpanPlan, fields map[string]any, events []SpanEvent) zipkinSpan {
+	zs := zipkinSpan{
+		TraceID:       span.TraceID.String(),
+		ID:            span.SpanID.String(),
+		Name:          span.Name,
+		Kind:          zipkinKinds[span.Kind],
+		Timestamp:     span.Start.UnixMicro(),
+		Duration:      max(1, span.Duration().Microseconds()),
+		LocalEndpoint: &zipkinEndpoint{ServiceName: span.ServiceName()},
+		Tags:          make(map[string]string, len(fields)+len(span.Attributes)),
+	}
+	if !span.IsRoot() {
+		zs.ParentID = span.ParentID.String()
+	}
+	for k, v := range fields {
+		zs.Tags[k] = fmt.Sprint(v)
+	}
+	for k, v := range span.Attributes {
+		zs.Tags[k] = fmt.Sprint(v)
+	}
+	if peer, ok := span.Attributes["peer.service"].(string); ok {
+		zs.RemoteEndpoint = &zipkinEndpoint{ServiceName: peer}
+	}
+	if e := span.Error; e != nil {
+		// Zipkin marks failed spans with an error tag holding the message
+		zs.Tags["error"] = e.Message
+		zs.Tags["exception.type"] = e.Type
+		zs.Tags["exception.stacktrace"] = e.Stacktrace
+	}
+	for _, ev := range events {
+		zs.Annotations = append(zs.Annotations, zipkinAnnotation{Timestamp: ev.Time.UnixMicro(), Value: ev.Name})
+	}
+	return zs
+}
+
+// SenderZipkin sends spans as Zipkin v2 JSON, in batches posted to a Zipkin
+// collector's span endpoint, which is /api/v2/spans by default.
+type SenderZipkin struct {
+	log    Logger
+	poster *httpPoster
+	queue  *batchQueue[zipkinSpan]
+	spans  atomic.Int64
+	failed atomic.Int64
+}
+
+func NewSenderZipkin(log Logger, opts *Options) *SenderZipkin {
+	u := *opts.apihost
+	u.Path = opts.Output.ZipkinPath
+	t := &SenderZipkin{
+		log:    log,
+		poster: newHTTPPoster(log, opts, u.String(), nil, compression(opts, "zipkin")),
+	}
+	t.queue = newBatchQueue(log, opts, t.send)
+	return t
+}
+
+// send posts a batch of spans.
+func (t *SenderZipkin) send(batch []zipkinSpan) {
+	t.spans.Add(int64(len(batch)))
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.log.Error("unable to encode batch of %d spans: %v\n", len(batch), err)
+		t.failed.Add(int64(len(batch)))
+		return
+	}
+	resp, err := t.poster.post(body, "application/json")
+	if err != nil {
+		t.log.Error("batch of %d spans failed: %v\n", len(batch), err)
+		t.failed.Add(int64(len(batch)))
+		return
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		t.log.Error("batch of %d spans failed with %v\n", len(batch), err)
+		t.failed.Add(int64(len(batch)))
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	t.log.Info("batch of %d spans: status %d\n", len(batch), resp.StatusCode)
+}
+
+func (t *SenderZipkin) CreateTrace(ctx context.Context, span *SpanPlan, fielder *Fielder, count int64) (context.Context, Sendable) {
+	return ctx, queuedSendable[zipkinSpan]{queue: t.queue, item: newZipkinSpan(span, fielder.GetFields(count, 0), fielder.GetEvents(span))}
+}
+
+func (t *SenderZipkin) CreateSpan(ctx context.Context, span *SpanPlan, fielder *Fielder) (context.Context, Sendable) {
+	return ctx, queuedSendable[zipkinSpan]{queue: t.queue, item: newZipkinSpan(span, fielder.GetFields(0, span.Level), fielder.GetEvents(span))}
+}
+
+// Close sends everything that's queued, then reports what happened to it.
+func (t *SenderZipkin) Close() {
+	t.queue.close()
+	t.log.Warn("sender sent %d spans: %d failed, %d dropped\n", t.spans.Load(), t.failed.Load(), t.queue.dropped.Load())
+	t.poster.bytes.report(t.log)
+}
+
+// Delivered returns the number of spans posted successfully, and the number
+// that failed or were dropped.
+func (t *SenderZipkin) Delivered() (int64, int64) {
+	return t.spans.Load() - t.failed.Load(), t.failed.Load() + t.queue.dropped.Load()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_newZipkinSpan(t *testing.T) {
+	start := time.Now()
+	root := newRootPlan("checkout", start)
+	root.End = start.Add(20 * time.Millisecond)
+	child := root.addChild("GET /cart", start.Add(time.Millisecond))
+	child.End = start.Add(10 * time.Millisecond)
+	child.Service = "frontend"
+	child.Kind = trace.SpanKindClient
+	child.setAttribute("peer.service", "cart")
+	child.Error = &SpanError{Type: "Error", Message: "ECONNRESET"}
+
+	zs := newZipkinSpan(child, map[string]any{"count": int64(3)}, []SpanEvent{{Name: "retry", Time: start.Add(2 * time.Millisecond)}})
+	if zs.TraceID != root.TraceID.String() || zs.ParentID != root.SpanID.String() || zs.ID != child.SpanID.String() {
+		t.Errorf("IDs don't match the plan: %+v", zs)
+	}
+	if zs.Kind != "CLIENT" || zs.LocalEndpoint.ServiceName != "frontend" || zs.RemoteEndpoint == nil || zs.RemoteEndpoint.ServiceName != "cart" {
+		t.Errorf("unexpected kind or endpoints: %+v", zs)
+	}
+	if zs.Timestamp != child.Start.UnixMicro() || zs.Duration != 9000 {
+		t.Errorf("unexpected timestamp %d or duration %d", zs.Timestamp, zs.Duration)
+	}
+	if zs.Tags["count"] != "3" || zs.Tags["error"] != "ECONNRESET" {
+		t.Errorf("unexpected tags %v", zs.Tags)
+	}
+	if len(zs.Annotations) != 1 || zs.Annotations[0].Value != "retry" {
+		t.Errorf("unexpected annotations %v", zs.Annotations)
+	}
+
+	zr := newZipkinSpan(root, nil, nil)
+	if zr.ParentID != "" || zr.Kind != "" || zr.LocalEndpoint.ServiceName != "checkout" {
+		t.Errorf("unexpected root span %+v", zr)
+	}
+}
+
+func Test_SenderZipkin(t *testing.T) {
+	var mut sync.Mutex
+	var received []zipkinSpan
+	host := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/spans" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var batch []zipkinSpan
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("unable to decode batch: %v", err)
+		}
+		mut.Lock()
+		received = append(received, batch...)
+		mut.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	opts := &Options{}
+	opts.apihost = host
+	opts.Output.ZipkinPath = "/api/v2/spans"
+	opts.Output.Concurrency = 2
+	sender := NewSenderZipkin(NewLogger(0), opts)
+	sendSpans(t, sender, 1)
+
+	if len(received) != 2 || sender.failed.Load() != 0 {
+		t.Errorf("expected 2 spans to be received, got %d with %d failed", len(received), sender.failed.Load())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the port that services listen on, for the address attributes of calls
+const servicePort = 8080
+
+// a server span starts and ends this much inside its client span, network
+// permitting, to account for time on the wire
+const maxNetworkDelay = 2 * time.Millisecond
+
+// AddSpanKinds gives every span in a planned trace an OTel span kind, and turns
+// each call that crosses a service boundary into a pair of spans: a CLIENT span
+// in the caller, wrapping a SERVER span in the callee (or a PRODUCER span
+// followed by a CONSUMER span if the call is asynchronous, which the plan marks
+// by making the callee a CONSUMER, or by detaching it). Both spans carry
+// attributes naming the other side. The root span is a SERVER; calls within a
+// service are INTERNAL.
+func AddSpanKinds(root *SpanPlan) {
+	if root.Kind == trace.SpanKindUnspecified {
+		root.Kind = trace.SpanKindServer
+	}
+	addSpanKinds(root)
+}
+
+func addSpanKinds(span *SpanPlan) {
+	for i, child := range span.Children {
+		if child.ServiceName() == span.ServiceName() {
+			if child.Kind == trace.SpanKindUnspecified {
+				child.Kind = trace.SpanKindInternal
+			}
+		} else {
+			if child.Detached && child.Kind == trace.SpanKindUnspecified {
+				// a call that isn't waited for goes through a queue
+				child.Kind = trace.SpanKindConsumer
+			}
+			span.Children[i] = insertClient(span, child)
+		}
+		addSpanKinds(child)
+	}
+}
+
+// insertClient makes a client span in the caller for the call to callee, puts
+// it between the two, and returns it.
+func insertClient(caller, callee *SpanPlan) *SpanPlan {
+	client := &SpanPlan{
+		TraceID:  callee.TraceID,
+		SpanID:   newSpanID(),
+		ParentID: caller.SpanID,
+		Service:  caller.ServiceName(),
+		Name:     callee.Name,
+		Level:    callee.Level,
+		Start:    callee.Start,
+		End:      callee.End,
+		Children: []*SpanPlan{callee},
+	}
+	callee.ParentID = client.SpanID
+	callee.setLevel(client.Level + 1)
+
+	address := strings.ReplaceAll(callee.ServiceName(), " ", "-")
+	if callee.Kind == trace.SpanKindConsumer {
+		// the producer only takes as long as sending the message, and the
+		// consumer picks it up after that
+		delay := min(maxNetworkDelay, callee.Duration()/20)
+		client.End = client.Start.Add(delay)
+		callee.Shift(delay)
+		client.Kind = trace.SpanKindProducer
+		client.setAttribute("messaging.system", "kafka")
+		client.setAttribute("messaging.operation", "publish")
+		client.setAttribute("messaging.destination.name", address)
+		callee.setAttribute("messaging.system", "kafka")
+		callee.setAttribute("messaging.operation", "process")
+		callee.setAttribute("messaging.destination.name", address)
+	} else {
+		client.Kind = trace.SpanKindClient
+		callee.Kind = trace.SpanKindServer
+		client.setAttribute("peer.service", callee.ServiceName())
+		client.setAttribute("net.peer.name", address)
+		client.setAttribute("server.address", address)
+		client.setAttribute("server.port", int64(servicePort))
+		callee.setAttribute("server.address", address)
+		callee.setAttribute("server.port", int64(servicePort))
+		callee.setAttribute("client.address", strings.ReplaceAll(caller.ServiceName(), " ", "-"))
+
+		// the server starts a little after the client and finishes a little
+		// before it, as long as that leaves room for the server's own calls;
+		// detached calls may end after the server does, so only their starts
+		// count
+		delay := min(maxNetworkDelay, callee.Duration()/20)
+		for _, c := range callee.Children {
+			delay = min(delay, c.Start.Sub(callee.Start))
+			if !c.Detached {
+				delay = min(delay, callee.End.Sub(c.End))
+			}
+		}
+		delay = max(delay, 0)
+		callee.Start = callee.Start.Add(delay)
+		callee.End = callee.End.Add(-delay)
+	}
+
+	// the caller sees the callee's failure
+	if e := callee.Error; e != nil {
+		client.Error = &SpanError{Type: e.Type, Message: e.Message, Propagated: true}
+	}
+	return client
+}
+
+// setLevel sets the level of this span, and renumbers its descendants to match.
+func (s *SpanPlan) setLevel(level int) {
+	s.Level = level
+	for _, child := range s.Children {
+		child.setLevel(level + 1)
+	}
+}
+
+func (s *SpanPlan) setAttribute(key string, value any) {
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[key] = value
+}
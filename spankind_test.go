@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_AddSpanKinds(t *testing.T) {
+	start := time.Now()
+	root := newRootPlan("frontend", start)
+	root.End = start.Add(100 * time.Millisecond)
+	local := root.addChild("frontend", start.Add(time.Millisecond))
+	local.End = start.Add(10 * time.Millisecond)
+	cart := root.addChild("cart", start.Add(20*time.Millisecond))
+	cart.End = start.Add(60 * time.Millisecond)
+	// the cart service doesn't wait for this, so it ends after cart does
+	audit := cart.addChild("cart", start.Add(30*time.Millisecond))
+	audit.End = start.Add(65 * time.Millisecond)
+	audit.Detached = true
+	queue := root.addChild("mailer", start.Add(70*time.Millisecond))
+	queue.End = start.Add(80 * time.Millisecond)
+	queue.Kind = trace.SpanKindConsumer
+	queue.Detached = true
+
+	AddSpanKinds(root)
+	checkPlan(t, root, nil)
+	if root.Kind != trace.SpanKindServer || local.Kind != trace.SpanKindInternal {
+		t.Errorf("root is %v and local call is %v", root.Kind, local.Kind)
+	}
+	client := root.Children[1]
+	if client.Kind != trace.SpanKindClient || client.Service != "frontend" || client.Children[0] != cart {
+		t.Errorf("unexpected client span %+v", client)
+	}
+	if cart.Kind != trace.SpanKindServer || cart.Level != 2 || client.Attributes["server.address"] != "cart" {
+		t.Errorf("unexpected server span %+v under %+v", cart, client)
+	}
+	if cart.Duration() >= client.Duration() || cart.Start.Before(client.Start) || cart.End.After(client.End) {
+		t.Errorf("server span should be within its client span")
+	}
+	if producer := root.Children[2]; producer.Kind != trace.SpanKindProducer || queue.Kind != trace.SpanKindConsumer {
+		t.Errorf("async call is %v -> %v", producer.Kind, queue.Kind)
+	}
+}
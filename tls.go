@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig returns the TLS configuration given by the TLS options: a CA
+// bundle to verify the host's certificate with instead of the system's, a
+// client certificate and key for mutual TLS, a server name to verify the
+// certificate against instead of the host's, and whether to skip verification
+// entirely. It returns nil if none of them are set, so that every client keeps
+// its own defaults.
+func newTLSConfig(opts *Options) (*tls.Config, error) {
+	t := opts.Telemetry
+	if t.CACert == "" && t.ClientCert == "" && t.ClientKey == "" && t.ServerName == "" && !t.SkipVerify {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.SkipVerify,
+	}
+	if t.CACert != "" {
+		pem, err := os.ReadFile(t.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CACert)
+		}
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return nil, fmt.Errorf("a client certificate and key must be given together")
+	}
+	if t.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsConfig returns a copy of the TLS configuration for a client, or the
+// default one if there are no TLS options.
+func tlsConfig(opts *Options) *tls.Config {
+	if opts.tls == nil {
+		return &tls.Config{}
+	}
+	return opts.tls.Clone()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key, written to PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if
+// parent is nil, and writes it to dir.
+func newTestCert(t *testing.T, dir, name string, parent *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_newTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", ca, "collector.internal")
+	clientCert := newTestCert(t, dir, "client", ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	cert, err := tls.LoadX509KeyPair(serverCert.certFile, serverCert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+	}
+	server.TLS.ClientCAs.AddCert(ca.cert)
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(opts *Options) error {
+		var err error
+		if opts.tls, err = newTLSConfig(opts); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := newHTTPClient(opts, 1).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	opts := &Options{}
+	opts.Telemetry.CACert = ca.certFile
+	opts.Telemetry.ServerName = "collector.internal"
+	if err := get(opts); err == nil {
+		t.Errorf("expected the server to require a client certificate")
+	}
+	opts.Telemetry.ClientCert = clientCert.certFile
+	opts.Telemetry.ClientKey = clientCert.keyFile
+	if err := get(opts); err != nil {
+		t.Errorf("mutual TLS failed: %v", err)
+	}
+	opts.Telemetry.ServerName = ""
+	if err := get(opts); err == nil {
+		t.Errorf("expected the certificate not to match 127.0.0.1")
+	}
+	opts.Telemetry.CACert = ""
+	opts.Telemetry.SkipVerify = true
+	if err := get(opts); err != nil {
+		t.Errorf("skipping verification failed: %v", err)
+	}
+
+	opts = &Options{}
+	if config, err := newTLSConfig(opts); config != nil || err != nil {
+		t.Errorf("expected no TLS config without TLS options, got %v, %v", config, err)
+	}
+	opts.Telemetry.ClientCert = clientCert.certFile
+	if _, err := newTLSConfig(opts); err == nil {
+		t.Errorf("expected an error for a client certificate without a key")
+	}
+	opts = &Options{}
+	opts.Telemetry.CACert = clientCert.keyFile
+	if _, err := newTLSConfig(opts); err == nil {
+		t.Errorf("expected an error for a CA bundle without certificates")
+	}
+}
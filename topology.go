@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"pgregory.net/rand"
+)
+
+// traces through a topology with cycles stop growing at this depth
+const maxTopologyDepth = 32
+
+// traces through a topology stop growing once they have this many spans, so
+// that fanouts in a cycle or a deep graph can't multiply without limit
+const maxTopologySpans = 10000
+
+// A Topology is a declarative graph of services, the operations each of them
+// provides, and the downstream calls each operation makes. When a topology is
+// configured, the generator builds each trace by walking the graph from one of
+// its roots, instead of inventing a random tree from --depth and --nspans.
+type Topology struct {
+	// Roots are the services at which traces start, chosen at random
+	Roots    []string                    `yaml:"roots"`
+	Services map[string]*TopologyService `yaml:"services"`
+}
+
+type TopologyService struct {
+	Operations []*TopologyOperation `yaml:"operations"`
+}
+
+type TopologyOperation struct {
+	Name string `yaml:"name"`
+	// Weight is the relative likelihood of choosing this operation when a
+	// caller doesn't name one (default 1)
+	Weight *float64       `yaml:"weight,omitempty"`
+	Calls  []TopologyCall `yaml:"calls,omitempty"`
+}
+
+// A TopologyCall is a call from an operation to another service.
+type TopologyCall struct {
+	Service string `yaml:"service"`
+	// Operation is the operation to call; if empty, one is chosen by weight
+	Operation string `yaml:"operation,omitempty"`
+	// Probability is the percentage chance that the call is made at all (default 100)
+	Probability *float64 `yaml:"probability,omitempty"`
+	// FanOut is the number of times the call is made (default 1)
+	FanOut *int `yaml:"fanout,omitempty"`
+	// Parallel makes the fanned-out calls overlap instead of running one after another
+	Parallel bool `yaml:"parallel,omitempty"`
+	// Async makes the call go through a message queue; the callee is a consumer
+	// and the caller doesn't wait for it to finish
+	Async bool `yaml:"async,omitempty"`
+	// NewTrace makes an async call continue in a new trace, linked to the caller
+	NewTrace bool `yaml:"newtrace,omitempty"`
+}
+
+// Validate checks that everything the topology refers to exists and that its
+// numbers are in range, and fills in defaults for the ones that aren't given.
+func (t *Topology) Validate() error {
+	if len(t.Roots) == 0 {
+		return fmt.Errorf("topology has no roots")
+	}
+	for _, root := range t.Roots {
+		if t.Services[root] == nil {
+			return fmt.Errorf("topology root %s is not a service", root)
+		}
+	}
+	for name, svc := range t.Services {
+		if svc == nil || len(svc.Operations) == 0 {
+			return fmt.Errorf("topology service %s has no operations", name)
+		}
+		totalWeight := 0.0
+		for _, op := range svc.Operations {
+			if op.Name == "" {
+				return fmt.Errorf("topology service %s has an operation with no name", name)
+			}
+			if op.Weight == nil {
+				op.Weight = ptr(1.0)
+			}
+			if *op.Weight < 0 {
+				return fmt.Errorf("%s %s has a negative weight", name, op.Name)
+			}
+			totalWeight += *op.Weight
+			for i := range op.Calls {
+				call := &op.Calls[i]
+				target := t.Services[call.Service]
+				if target == nil {
+					return fmt.Errorf("%s %s calls unknown service %s", name, op.Name, call.Service)
+				}
+				if call.Operation != "" && target.operation(call.Operation) == nil {
+					return fmt.Errorf("%s %s calls unknown operation %s %s", name, op.Name, call.Service, call.Operation)
+				}
+				if call.Probability == nil {
+					call.Probability = ptr(100.0)
+				}
+				if *call.Probability < 0 || *call.Probability > 100 {
+					return fmt.Errorf("%s %s calls %s with probability %v, which is not between 0 and 100",
+						name, op.Name, call.Service, *call.Probability)
+				}
+				if call.FanOut == nil {
+					call.FanOut = ptr(1)
+				}
+				if *call.FanOut < 1 {
+					return fmt.Errorf("%s %s calls %s with fanout %d, which is less than 1",
+						name, op.Name, call.Service, *call.FanOut)
+				}
+			}
+		}
+		if totalWeight == 0 {
+			return fmt.Errorf("topology service %s has no operation with a weight above 0", name)
+		}
+	}
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func (s *TopologyService) operation(name string) *TopologyOperation {
+	for _, op := range s.Operations {
+		if op.Name == name {
+			return op
+		}
+	}
+	return nil
+}
+
+// pickOperation chooses one of the service's operations by weight.
+func (s *TopologyService) pickOperation() *TopologyOperation {
+	total := 0.0
+	for _, op := range s.Operations {
+		total += *op.Weight
+	}
+	r := rand.Float64() * total
+	for _, op := range s.Operations {
+		r -= *op.Weight
+		if r < 0 {
+			return op
+		}
+	}
+	return s.Operations[len(s.Operations)-1]
+}
+
+// PlanTrace plans a trace that starts at one of the roots at the given time,
+// and takes no more than budget.
+func (t *Topology) PlanTrace(start time.Time, budget time.Duration) *SpanPlan {
+	service := t.Roots[rand.Intn(len(t.Roots))]
+	op := t.Services[service].pickOperation()
+	root := newRootPlan(op.Name, start)
+	root.Service = service
+	spans := maxTopologySpans - 1
+	t.planCalls(root, op, budget, &spans)
+	return root
+}
+
+// planCalls plans the calls made by span's operation, then sets the end of
+// span to cover them. Like the random planner, each span spends a random part
+// of its budget on itself, half before its calls and half after, and the rest
+// is divided between the calls. No more than spans more spans are planned; it's
+// decremented for each one.
+func (t *Topology) planCalls(span *SpanPlan, op *TopologyOperation, budget time.Duration, spans *int) {
+	calls := make([]TopologyCall, 0, len(op.Calls))
+	if span.Level < maxTopologyDepth {
+		for _, call := range op.Calls {
+			if rand.Float64()*100 < *call.Probability {
+				calls = append(calls, call)
+			}
+		}
+	}
+	if len(calls) == 0 || budget <= 0 {
+		span.End = span.Start.Add(randDuration(budget))
+		return
+	}
+
+	self := randDuration(budget / time.Duration(len(calls)+1))
+	perCall := (budget - self) / time.Duration(len(calls))
+	t0 := span.Start.Add(self / 2)
+	for _, call := range calls {
+		target := t.Services[call.Service]
+		each := perCall
+		if !call.Parallel {
+			each /= time.Duration(*call.FanOut)
+		}
+		end := t0
+		for range *call.FanOut {
+			if *spans <= 0 {
+				break
+			}
+			*spans--
+			callee := target.operation(call.Operation)
+			if callee == nil {
+				callee = target.pickOperation()
+			}
+			child := span.addChild(callee.Name, t0)
+			child.Service = call.Service
+			t.planCalls(child, callee, each, spans)
+			if call.Async || call.NewTrace {
+				// the caller only waits for the message to be sent
+				child.Kind = trace.SpanKindConsumer
+				child.Detached = true
+				child.NewTrace = call.NewTrace
+				continue
+			}
+			end = maxTime(end, child.End)
+			if !call.Parallel {
+				t0 = child.End
+			}
+		}
+		t0 = end
+	}
+	span.End = t0.Add(self / 2)
+}
+
+// randDuration returns a random duration between 0 and d.
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
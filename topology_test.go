@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testTopology = `
+roots: [frontend]
+services:
+  frontend:
+    operations:
+      - name: GET /checkout
+        calls:
+          - service: checkout
+            operation: PlaceOrder
+          - service: cart
+            fanout: 3
+            parallel: true
+  checkout:
+    operations:
+      - name: PlaceOrder
+        calls:
+          - service: payment
+  cart:
+    operations:
+      - name: GetCart
+  payment:
+    operations:
+      - name: Charge
+`
+
+func Test_TopologyPlanTrace(t *testing.T) {
+	var topo Topology
+	if err := yaml.Unmarshal([]byte(testTopology), &topo); err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	root := topo.PlanTrace(start, time.Second)
+	checkPlan(t, root, nil)
+	if root.Service != "frontend" || root.Name != "GET /checkout" {
+		t.Errorf("root is %s %s", root.Service, root.Name)
+	}
+	if root.Count() != 6 {
+		t.Errorf("planned %d spans, want 6", root.Count())
+	}
+	if root.Duration() > time.Second {
+		t.Errorf("trace took %v", root.Duration())
+	}
+	checkout := root.Children[0]
+	if checkout.Service != "checkout" || len(checkout.Children) != 1 || checkout.Children[0].Service != "payment" {
+		t.Errorf("unexpected checkout span %+v", checkout)
+	}
+	carts := root.Children[1:]
+	for _, cart := range carts {
+		if cart.Service != "cart" || !cart.Start.Equal(carts[0].Start) {
+			t.Errorf("expected parallel cart calls, got %s at %v", cart.Service, cart.Start)
+		}
+	}
+}
+
+func Test_TopologyValidate(t *testing.T) {
+	for name, topo := range map[string]string{
+		"no roots":          `services: {a: {operations: [{name: x}]}}`,
+		"unknown root":      `{roots: [b], services: {a: {operations: [{name: x}]}}}`,
+		"no operations":     `{roots: [a], services: {a: {}}}`,
+		"unknown service":   `{roots: [a], services: {a: {operations: [{name: x, calls: [{service: b}]}]}}}`,
+		"unknown operation": `{roots: [a], services: {a: {operations: [{name: x, calls: [{service: a, operation: y}]}]}}}`,
+		"negative weight":   `{roots: [a], services: {a: {operations: [{name: x, weight: -1}]}}}`,
+		"no weight":         `{roots: [a], services: {a: {operations: [{name: x, weight: 0}]}}}`,
+		"probability":       `{roots: [a], services: {a: {operations: [{name: x, calls: [{service: a, probability: 101}]}]}}}`,
+		"fanout":            `{roots: [a], services: {a: {operations: [{name: x, calls: [{service: a, fanout: 0}]}]}}}`,
+	} {
+		var tp Topology
+		if err := yaml.Unmarshal([]byte(topo), &tp); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := tp.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_TopologyLimits(t *testing.T) {
+	// a call with probability 0 is never made, and an operation with weight 0
+	// is never chosen
+	var topo Topology
+	err := yaml.Unmarshal([]byte(`
+roots: [a]
+services:
+  a:
+    operations:
+      - name: x
+        weight: 0
+      - name: y
+        calls: [{service: a, probability: 0}]
+`), &topo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for range 100 {
+		if root := topo.PlanTrace(time.Now(), time.Second); root.Name != "y" || root.Count() != 1 {
+			t.Fatalf("expected a single y span, got %s with %d spans", root.Name, root.Count())
+		}
+	}
+
+	// a cycle that fans out would grow as 10^32 without a limit on spans
+	topo = Topology{}
+	err = yaml.Unmarshal([]byte(`
+roots: [a]
+services:
+  a:
+    operations:
+      - name: x
+        calls: [{service: a, fanout: 10, parallel: true}]
+`), &topo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	root := topo.PlanTrace(time.Now(), time.Second)
+	if root.Count() != maxTopologySpans {
+		t.Errorf("planned %d spans, want %d", root.Count(), maxTopologySpans)
+	}
+	checkPlan(t, root, nil)
+}